@@ -0,0 +1,162 @@
+// Package account handles account deletion and personal data export
+package account
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/respond"
+	"astromatch/storage"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config controls account deletion and data export
+type Config struct {
+	// DeletionGracePeriod is how long a deleted account can still be restored by logging in
+	DeletionGracePeriod time.Duration `yaml:"deletionGracePeriod" json:"deletionGracePeriod" env:"ACCOUNT_DELETION_GRACE_PERIOD"`
+	// PurgeInterval is how often expired accounts and exports are purged
+	PurgeInterval time.Duration `yaml:"purgeInterval" json:"purgeInterval" env:"ACCOUNT_PURGE_INTERVAL"`
+	// ExportTTL is how long a finished export can be downloaded
+	ExportTTL time.Duration `yaml:"exportTtl" json:"exportTtl" env:"ACCOUNT_EXPORT_TTL"`
+}
+
+// Active settings, set by Init
+var settings = Config{
+	DeletionGracePeriod: 30 * 24 * time.Hour,
+	PurgeInterval:       time.Hour,
+	ExportTTL:           48 * time.Hour,
+}
+
+// Init applies cfg, keeping defaults for unset values
+func Init(cfg Config) {
+	if cfg.DeletionGracePeriod > 0 {
+		settings.DeletionGracePeriod = cfg.DeletionGracePeriod
+	}
+	if cfg.PurgeInterval > 0 {
+		settings.PurgeInterval = cfg.PurgeInterval
+	}
+	if cfg.ExportTTL > 0 {
+		settings.ExportTTL = cfg.ExportTTL
+	}
+}
+
+// exportRetryAfter is the polling interval suggested to clients while an export runs
+const exportRetryAfter = 5 * time.Second
+
+// Handler serves the account endpoints using the injected repositories
+type Handler struct {
+	store *db.Store
+}
+
+// NewHandler creates account handlers backed by store
+func NewHandler(store *db.Store) *Handler {
+	return &Handler{store: store}
+}
+
+// deletionResponse tells the client when the account will be gone for good
+type deletionResponse struct {
+	Status  string    `json:"status"`
+	PurgeAt time.Time `json:"purgeAt"`
+}
+
+// Me handles DELETE /api/v1/users/me: the account is hidden immediately and purged
+// after the grace period. Logging in before then restores it.
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	user, err := h.store.Users.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to delete account")
+		return
+	}
+
+	// Repeated requests keep the original schedule
+	if user.DeletedAt == nil {
+		now := time.Now().UTC()
+		user, err = h.store.Users.UpdateFields(r.Context(), user.ID, map[string]interface{}{db.FieldDeletedAt: now}, db.AnyVersion)
+		if err != nil {
+			respond.DBError(w, r, err, "Failed to delete account")
+			return
+		}
+		log.Printf("Account deletion requested: %s", user.ID)
+	}
+
+	// End the session on this device
+	auth.EndSession(w)
+	respond.JSON(w, http.StatusAccepted, deletionResponse{
+		Status:  "scheduled",
+		PurgeAt: user.DeletedAt.Add(settings.DeletionGracePeriod),
+	})
+}
+
+// Export handles GET /api/v1/users/me/export. The first call queues an export and
+// answers 202 with the job; later calls answer 202 until the archive is ready,
+// then stream it as a ZIP file.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	now := time.Now().UTC()
+	job, err := h.store.Exports.Latest(r.Context(), claims.UserID)
+	if err != nil && !errors.Is(err, db.ErrExportNotFound) {
+		respond.DBError(w, r, err, "Failed to export data")
+		return
+	}
+
+	// Start over when there is no usable job
+	if err != nil || job.Status == db.ExportFailed || job.ExpiresAt.Before(now) {
+		job = db.ExportJob{
+			ID:        uuid.New().String(),
+			UserID:    claims.UserID,
+			Status:    db.ExportPending,
+			CreatedAt: now,
+			ExpiresAt: now.Add(settings.ExportTTL),
+		}
+		if err := h.store.Exports.Create(r.Context(), job); err != nil {
+			respond.DBError(w, r, err, "Failed to export data")
+			return
+		}
+	}
+
+	if job.Status != db.ExportReady {
+		w.Header().Set("Retry-After", strconv.Itoa(int(exportRetryAfter.Seconds())))
+		respond.JSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	archive, err := storage.Blobs.Open(r.Context(), job.BlobKey)
+	if err != nil {
+		log.Printf("[%s] Failed to open export %s: %v", respond.RequestID(r.Context()), job.ID, err)
+		respond.Error(w, r, http.StatusBadGateway, respond.CodeUpstreamFailed, "Failed to read export")
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="astromatch-export-`+job.CreatedAt.Format("2006-01-02")+`.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err := io.Copy(w, archive); err != nil {
+		log.Printf("[%s] Failed to send export %s: %v", respond.RequestID(r.Context()), job.ID, err)
+	}
+}
@@ -0,0 +1,258 @@
+package account
+
+import (
+	"archive/zip"
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/db/memory"
+	"astromatch/storage"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *db.Store {
+	t.Helper()
+	previous := storage.Blobs
+	storage.Blobs = storage.NewLocalStore(t.TempDir(), "/media")
+	t.Cleanup(func() { storage.Blobs = previous })
+	return memory.NewStore()
+}
+
+func putBlob(t *testing.T, key, data string) {
+	t.Helper()
+	if err := storage.Blobs.Put(context.Background(), key, []byte(data), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func blobExists(key string) bool {
+	r, err := storage.Blobs.Open(context.Background(), key)
+	if err != nil {
+		return false
+	}
+	r.Close()
+	return true
+}
+
+func TestPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	expired := now.Add(-settings.DeletionGracePeriod - time.Hour)
+	recent := now.Add(-time.Hour)
+
+	photo := db.Photo{ID: "p1", ThumbnailKey: "users/gone/photos/p1/thumbnail.jpg", MediumKey: "users/gone/photos/p1/medium.jpg"}
+	putBlob(t, photo.ThumbnailKey, "thumb")
+	putBlob(t, photo.MediumKey, "medium")
+	putBlob(t, "private/exports/gone/e1.zip", "zip")
+	putBlob(t, "private/exports/active/e2.zip", "zip")
+
+	for _, u := range []db.User{
+		{ID: "gone", Email: "gone@example.com", Photos: []db.Photo{photo}, DeletedAt: &expired},
+		{ID: "grace", Email: "grace@example.com", DeletedAt: &recent},
+		{ID: "active", Email: "active@example.com"},
+	} {
+		if err := store.Users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Identities.Create(ctx, db.Identity{UserID: u.ID, Provider: db.ProviderEmail, Subject: u.Email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Preferences.Upsert(ctx, "gone", db.UserPreferences{PreferredSign: "Leo"})
+	store.OTPs.Save(ctx, db.UserOTP{Identifier: "gone@example.com", CodeHash: "x", ExpiresAt: now.Add(time.Hour)})
+	store.Exports.Create(ctx, db.ExportJob{ID: "e1", UserID: "gone", Status: db.ExportReady, BlobKey: "private/exports/gone/e1.zip", ExpiresAt: now.Add(time.Hour)})
+	store.Exports.Create(ctx, db.ExportJob{ID: "e2", UserID: "active", Status: db.ExportReady, BlobKey: "private/exports/active/e2.zip", ExpiresAt: now.Add(-time.Minute)})
+
+	purgeExpired(ctx, store, now)
+
+	if _, err := store.Users.GetByID(ctx, "gone"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("expired account still stored: %v", err)
+	}
+	for _, key := range []string{photo.ThumbnailKey, photo.MediumKey, "private/exports/gone/e1.zip", "private/exports/active/e2.zip"} {
+		if blobExists(key) {
+			t.Errorf("blob %s survived the purge", key)
+		}
+	}
+	if identities, _ := store.Identities.ListByUser(ctx, "gone"); len(identities) != 0 {
+		t.Errorf("identities survived the purge: %v", identities)
+	}
+	if otps, _ := store.OTPs.ListValid(ctx, "gone@example.com", now); len(otps) != 0 {
+		t.Errorf("OTPs survived the purge: %v", otps)
+	}
+	if _, err := store.Preferences.Get(ctx, "gone"); !errors.Is(err, db.ErrPreferencesNotFound) {
+		t.Errorf("preferences survived the purge: %v", err)
+	}
+	if jobs, _ := store.Exports.ListByUser(ctx, "gone"); len(jobs) != 0 {
+		t.Errorf("export jobs survived the purge: %v", jobs)
+	}
+	if jobs, _ := store.Exports.ListByUser(ctx, "active"); len(jobs) != 0 {
+		t.Errorf("expired export was kept: %v", jobs)
+	}
+
+	for _, id := range []string{"grace", "active"} {
+		if _, err := store.Users.GetByID(ctx, id); err != nil {
+			t.Errorf("account %s was purged: %v", id, err)
+		}
+		if identities, _ := store.Identities.ListByUser(ctx, id); len(identities) != 1 {
+			t.Errorf("identities of %s were touched: %v", id, identities)
+		}
+	}
+}
+
+// restoringIdentities cancels an account's deletion, as a login would, while the
+// purge is deleting its identities
+type restoringIdentities struct {
+	db.IdentityRepository
+	users  db.UserRepository
+	userID string
+}
+
+func (r restoringIdentities) DeleteByUser(ctx context.Context, userID string) error {
+	if userID == r.userID {
+		r.users.UpdateFields(ctx, userID, map[string]interface{}{db.FieldDeletedAt: nil}, db.AnyVersion)
+	}
+	return r.IdentityRepository.DeleteByUser(ctx, userID)
+}
+
+func TestPurgeKeepsRestoredAccounts(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	expired := now.Add(-settings.DeletionGracePeriod - time.Hour)
+
+	photo := db.Photo{ID: "p1", ThumbnailKey: "users/back/photos/p1/thumbnail.jpg", MediumKey: "users/back/photos/p1/medium.jpg"}
+	putBlob(t, photo.ThumbnailKey, "thumb")
+	putBlob(t, photo.MediumKey, "medium")
+	for _, u := range []db.User{
+		{ID: "back", Photos: []db.Photo{photo}, DeletedAt: &expired},
+		{ID: "late", DeletedAt: &expired},
+	} {
+		if err := store.Users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Identities = restoringIdentities{IdentityRepository: store.Identities, users: store.Users, userID: "late"}
+
+	// back logs in after the pass listed it, late while it is being purged
+	listed, _ := store.Users.ListDeletedBefore(ctx, now.Add(-settings.DeletionGracePeriod))
+	store.Users.UpdateFields(ctx, "back", map[string]interface{}{db.FieldDeletedAt: nil}, db.AnyVersion)
+	for _, user := range listed {
+		if err := purgeUser(ctx, store, user.ID, now.Add(-settings.DeletionGracePeriod)); !errors.Is(err, errRestored) {
+			t.Errorf("%s: got %v, want errRestored", user.ID, err)
+		}
+	}
+
+	for _, id := range []string{"back", "late"} {
+		if _, err := store.Users.GetByID(ctx, id); err != nil {
+			t.Errorf("restored account %s was deleted: %v", id, err)
+		}
+	}
+	for _, key := range []string{photo.ThumbnailKey, photo.MediumKey} {
+		if !blobExists(key) {
+			t.Errorf("photo %s of a restored account was deleted", key)
+		}
+	}
+}
+
+// exportRequest calls Export as userID
+func exportRequest(h *Handler, userID string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users/me/export", nil)
+	r = r.WithContext(auth.WithClaims(r.Context(), &auth.Claims{UserID: userID}))
+	w := httptest.NewRecorder()
+	h.Export(w, r)
+	return w
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	photo := db.Photo{ID: "p1", MediumKey: "users/ada/photos/p1/medium.jpg"}
+	putBlob(t, photo.MediumKey, "jpeg")
+	if err := store.Users.Create(ctx, db.User{ID: "ada", Email: "ada@example.com", Password: "$2a$10$secret-hash", Photos: []db.Photo{photo}}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(store)
+
+	w := exportRequest(h, "ada")
+	if w.Code != http.StatusAccepted || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("first request: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := exportRequest(h, "ada"); w.Code != http.StatusAccepted {
+		t.Fatalf("pending request: status %d", w.Code)
+	}
+
+	now := time.Now().UTC()
+	job, err := store.Exports.ClaimPending(ctx, now, now.Add(-exportStaleAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Exports.ClaimPending(ctx, now, now.Add(-exportStaleAfter)); !errors.Is(err, db.ErrExportNotFound) {
+		t.Errorf("one request queued more than one job: %v", err)
+	}
+	runExport(ctx, store, job)
+
+	job, err = store.Exports.Latest(ctx, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != db.ExportReady || !strings.HasPrefix(job.BlobKey, storage.PrivatePrefix) {
+		t.Fatalf("job after run: %+v", job)
+	}
+
+	w = exportRequest(h, "ada")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("ready request: status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	var names []string
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	want := []string{"README.txt", "account.json", "identities.json", "passkeys.json", "photos/01-p1.jpg"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("archive holds %v, want %v", names, want)
+	}
+	if !strings.Contains(files["account.json"], "ada@example.com") || strings.Contains(files["account.json"], "secret-hash") {
+		t.Errorf("account.json: %s", files["account.json"])
+	}
+	if files["photos/01-p1.jpg"] != "jpeg" {
+		t.Errorf("photo content %q", files["photos/01-p1.jpg"])
+	}
+}
+
+func TestExportRestartsAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	store.Users.Create(ctx, db.User{ID: "ada"})
+	store.Exports.Create(ctx, db.ExportJob{ID: "old", UserID: "ada", Status: db.ExportReady, ExpiresAt: time.Now().Add(-time.Minute)})
+
+	if w := exportRequest(NewHandler(store), "ada"); w.Code != http.StatusAccepted {
+		t.Fatalf("status %d", w.Code)
+	}
+	job, err := store.Exports.Latest(ctx, "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "old" || job.Status != db.ExportPending {
+		t.Errorf("expired export was served instead of queueing a new one: %+v", job)
+	}
+}
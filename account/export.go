@@ -0,0 +1,180 @@
+package account
+
+import (
+	"archive/zip"
+	"astromatch/db"
+	"astromatch/profile"
+	"astromatch/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// Export worker timing
+const (
+	exportPollInterval = 2 * time.Second
+	// exportStaleAfter is when a running job is assumed abandoned by a crashed worker
+	exportStaleAfter = 10 * time.Minute
+)
+
+const exportReadme = `AstroMatch data export
+
+account.json      your account and profile, as stored (password hashes are never exported)
+identities.json   the login methods linked to your account
+passkeys.json     the passkeys you registered (public keys are not included)
+preferences.json  your matchmaking preferences, if you saved any
+photos/           your uploaded photos in the order shown on your profile
+`
+
+// exportPreferences is the exported form of db.UserPreferences
+type exportPreferences struct {
+	PreferredSign string   `json:"preferredSign,omitempty"`
+	MaxDistance   int      `json:"maxDistance,omitempty"`
+	Interests     []string `json:"interests,omitempty"`
+}
+
+// RunExporter builds queued data exports until ctx is cancelled
+func RunExporter(ctx context.Context, store *db.Store) {
+	for {
+		now := time.Now().UTC()
+		job, err := store.Exports.ClaimPending(ctx, now, now.Add(-exportStaleAfter))
+		switch {
+		case err == nil:
+			runExport(ctx, store, job)
+			continue
+		case !errors.Is(err, db.ErrExportNotFound) && ctx.Err() == nil:
+			log.Printf("Export: failed to claim job: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(exportPollInterval):
+		}
+	}
+}
+
+// runExport builds and stores the archive for job and records the outcome
+func runExport(ctx context.Context, store *db.Store, job db.ExportJob) {
+	archive, err := buildArchive(ctx, store, job.UserID)
+	if err == nil {
+		job.BlobKey = fmt.Sprintf("%sexports/%s/%s.zip", storage.PrivatePrefix, job.UserID, job.ID)
+		err = storage.Blobs.Put(ctx, job.BlobKey, archive, "application/zip")
+	}
+
+	completed := time.Now().UTC()
+	job.CompletedAt = &completed
+	if err != nil {
+		log.Printf("Export: job %s for %s failed: %v", job.ID, job.UserID, err)
+		job.Status = db.ExportFailed
+		job.Error = "Export failed, please request it again"
+		job.BlobKey = ""
+	} else {
+		job.Status = db.ExportReady
+		job.ExpiresAt = completed.Add(settings.ExportTTL)
+	}
+
+	if err := store.Exports.Save(ctx, job); err != nil {
+		log.Printf("Export: failed to save job %s: %v", job.ID, err)
+	}
+}
+
+// buildArchive zips everything stored about userID
+func buildArchive(ctx context.Context, store *db.Store, userID string) ([]byte, error) {
+	user, err := store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	if err := writeFile(zw, "README.txt", []byte(exportReadme)); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(zw, "account.json", profile.NewAdmin(user)); err != nil {
+		return nil, err
+	}
+
+	identities, err := store.Identities.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if identities == nil {
+		identities = []db.Identity{}
+	}
+	if err := writeJSON(zw, "identities.json", identities); err != nil {
+		return nil, err
+	}
+
+	passkeys, err := store.Passkeys.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if passkeys == nil {
+		passkeys = []db.Passkey{}
+	}
+	if err := writeJSON(zw, "passkeys.json", passkeys); err != nil {
+		return nil, err
+	}
+
+	prefs, err := store.Preferences.Get(ctx, userID)
+	switch {
+	case err == nil:
+		if err := writeJSON(zw, "preferences.json", exportPreferences{
+			PreferredSign: prefs.PreferredSign,
+			MaxDistance:   prefs.MaxDistance,
+			Interests:     prefs.Interests,
+		}); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, db.ErrPreferencesNotFound):
+		return nil, err
+	}
+
+	for i, photo := range user.Photos {
+		data, err := readBlob(ctx, photo.MediumKey)
+		if err != nil {
+			return nil, fmt.Errorf("reading photo %s: %w", photo.ID, err)
+		}
+		if err := writeFile(zw, fmt.Sprintf("photos/%02d-%s.jpg", i+1, photo.ID), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(zw, name, data)
+}
+
+func writeFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func readBlob(ctx context.Context, key string) ([]byte, error) {
+	r, err := storage.Blobs.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
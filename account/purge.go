@@ -0,0 +1,139 @@
+package account
+
+import (
+	"astromatch/db"
+	"astromatch/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// RunPurger permanently deletes accounts whose grace period has passed and
+// expired export archives, every PurgeInterval until ctx is cancelled
+func RunPurger(ctx context.Context, store *db.Store) {
+	ticker := time.NewTicker(settings.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purgeExpired(ctx, store, time.Now().UTC())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// errRestored means the account was restored after the purge pass listed it
+var errRestored = errors.New("account restored")
+
+// purgeExpired runs one purge pass; failures are logged and retried next pass
+func purgeExpired(ctx context.Context, store *db.Store, now time.Time) {
+	cutoff := now.Add(-settings.DeletionGracePeriod)
+	users, err := store.Users.ListDeletedBefore(ctx, cutoff)
+	if err != nil {
+		log.Printf("Purge: failed to list deleted accounts: %v", err)
+	}
+	for _, user := range users {
+		err := purgeUser(ctx, store, user.ID, cutoff)
+		if errors.Is(err, errRestored) {
+			log.Printf("Purge: account %s was restored, keeping it", user.ID)
+			continue
+		}
+		if err != nil {
+			log.Printf("Purge: failed to purge account %s: %v", user.ID, err)
+			continue
+		}
+		log.Printf("Purge: account %s permanently deleted", user.ID)
+	}
+
+	jobs, err := store.Exports.ListExpired(ctx, now)
+	if err != nil {
+		log.Printf("Purge: failed to list expired exports: %v", err)
+	}
+	for _, job := range jobs {
+		if err := deleteExport(ctx, store, job); err != nil {
+			log.Printf("Purge: failed to delete export %s: %v", job.ID, err)
+		}
+	}
+}
+
+// purgeUser removes everything stored about the user with id, as long as its
+// deletion was still requested before cutoff. Files and dependent records go
+// first and the user document last, so an interrupted purge is retried in full.
+// Swipes and messages have no store yet; they belong here once they do.
+func purgeUser(ctx context.Context, store *db.Store, id string, cutoff time.Time) error {
+	// The pass's list may be stale: logging in since then restores the account
+	user, err := store.Users.GetByID(ctx, id)
+	if errors.Is(err, db.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading user: %w", err)
+	}
+	if user.DeletedAt == nil || !user.DeletedAt.Before(cutoff) {
+		return errRestored
+	}
+
+	for _, photo := range user.Photos {
+		for _, key := range []string{photo.ThumbnailKey, photo.MediumKey} {
+			if err := storage.Blobs.Delete(ctx, key); err != nil {
+				return fmt.Errorf("deleting photo %s: %w", key, err)
+			}
+		}
+	}
+
+	jobs, err := store.Exports.ListByUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("listing exports: %w", err)
+	}
+	for _, job := range jobs {
+		if err := deleteExport(ctx, store, job); err != nil {
+			return err
+		}
+	}
+
+	if err := store.Passkeys.DeleteByUser(ctx, user.ID); err != nil {
+		return fmt.Errorf("deleting passkeys: %w", err)
+	}
+	if err := store.Identities.DeleteByUser(ctx, user.ID); err != nil {
+		return fmt.Errorf("deleting identities: %w", err)
+	}
+	if err := store.Preferences.Delete(ctx, user.ID); err != nil {
+		return fmt.Errorf("deleting preferences: %w", err)
+	}
+	for _, identifier := range []string{user.Email, user.Phone} {
+		if identifier == "" {
+			continue
+		}
+		if err := store.OTPs.DeleteByIdentifier(ctx, identifier); err != nil {
+			return fmt.Errorf("deleting OTPs: %w", err)
+		}
+	}
+
+	// Only if nobody restored the account meanwhile
+	err = store.Users.DeleteIfDeletedBefore(ctx, user.ID, cutoff)
+	if errors.Is(err, db.ErrUserNotFound) {
+		if _, err := store.Users.GetByID(ctx, user.ID); err == nil {
+			return errRestored
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+	return nil
+}
+
+// deleteExport removes an export archive and its job record
+func deleteExport(ctx context.Context, store *db.Store, job db.ExportJob) error {
+	if job.BlobKey != "" {
+		if err := storage.Blobs.Delete(ctx, job.BlobKey); err != nil {
+			return fmt.Errorf("deleting export archive %s: %w", job.BlobKey, err)
+		}
+	}
+	return store.Exports.Delete(ctx, job.ID)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig controls which browser origins may call the API
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as https://astromatch.app, or ones
+	// with a * standing for part of one host label, such as
+	// https://*.preview.astromatch.app. A lone * allows any origin but cannot be
+	// combined with credentials.
+	AllowedOrigins []string `yaml:"allowedOrigins" json:"allowedOrigins" env:"CORS_ALLOWED_ORIGINS"`
+	// AllowCredentials lets allowed origins send the session cookie
+	AllowCredentials bool `yaml:"allowCredentials" json:"allowCredentials" env:"CORS_ALLOW_CREDENTIALS"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"maxAge" json:"maxAge" env:"CORS_MAX_AGE"`
+}
+
+const (
+	corsAllowMethods  = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders  = "Content-Type, Authorization, If-Match, X-Request-ID"
+	corsExposeHeaders = "ETag, X-Request-ID, Retry-After"
+)
+
+// originPattern matches origins: exactly, or with a wildcard between prefix and suffix
+type originPattern struct {
+	prefix, suffix string
+	wildcard       bool
+}
+
+// Active policy, set by InitCORS; no origin is allowed until then
+var cors struct {
+	anyOrigin   bool
+	origins     []originPattern
+	credentials bool
+	maxAge      string
+}
+
+// InitCORS applies cfg. Origins are checked by CheckOrigin during config
+// validation; any that do not parse are ignored.
+func InitCORS(cfg CORSConfig) {
+	cors.anyOrigin = false
+	cors.origins = nil
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			cors.anyOrigin = true
+			continue
+		}
+		if p, err := parseOrigin(origin); err == nil {
+			cors.origins = append(cors.origins, p)
+		}
+	}
+	cors.credentials = cfg.AllowCredentials
+	cors.maxAge = ""
+	if cfg.MaxAge > 0 {
+		cors.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+}
+
+// CheckOrigin reports why origin is not a valid AllowedOrigins entry
+func CheckOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	_, err := parseOrigin(origin)
+	return err
+}
+
+func parseOrigin(origin string) (originPattern, error) {
+	origin = strings.ToLower(origin)
+	u, err := url.Parse(strings.Replace(origin, "*", "wildcard", 1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return originPattern{}, fmt.Errorf("%q is not an origin like https://example.com", origin)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return originPattern{}, fmt.Errorf("%q must not have a path, query or credentials", origin)
+	}
+
+	prefix, suffix, wildcard := strings.Cut(origin, "*")
+	if !wildcard {
+		return originPattern{prefix: origin}, nil
+	}
+	if strings.Contains(suffix, "*") || !strings.Contains(u.Hostname(), "wildcard") {
+		return originPattern{}, fmt.Errorf("%q may have one * in its host", origin)
+	}
+	return originPattern{prefix: prefix, suffix: suffix, wildcard: true}, nil
+}
+
+// matches reports whether origin fits p. A wildcard covers letters, digits and
+// dashes only, so it cannot reach across a dot into a host someone else controls.
+func (p originPattern) matches(origin string) bool {
+	if !p.wildcard {
+		return origin == p.prefix
+	}
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+	for _, c := range origin[len(p.prefix) : len(origin)-len(p.suffix)] {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func originAllowed(origin string) bool {
+	if cors.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, p := range cors.origins {
+		if p.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware applies the CORS policy to every route and answers preflight
+// requests itself. Disallowed origins get no CORS headers, so browsers block them.
+func CORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		// Responses differ by origin, so shared caches must not mix them up
+		header.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" && originAllowed(origin) {
+			if cors.anyOrigin {
+				// Never credentials: any site could then act as the signed-in user
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+				if cors.credentials {
+					header.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			if preflight {
+				header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+				header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				if cors.maxAge != "" {
+					header.Set("Access-Control-Max-Age", cors.maxAge)
+				}
+			} else {
+				header.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			}
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPatterns(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"https://astromatch.app", "https://astromatch.app", true},
+		{"https://astromatch.app", "http://astromatch.app", false},
+		{"https://astromatch.app", "https://astromatch.app:8443", false},
+		{"https://astromatch.app:8443", "https://astromatch.app:8443", true},
+		{"https://astromatch.app:8443", "https://astromatch.app:9443", false},
+		{"https://*.preview.astromatch.app", "https://pr-42.preview.astromatch.app", true},
+		{"https://*.preview.astromatch.app", "https://preview.astromatch.app", false},
+		{"https://*.preview.astromatch.app", "https://.preview.astromatch.app", false},
+		// The wildcard covers one label, never a dot into another domain
+		{"https://*.preview.astromatch.app", "https://evil.com.preview.astromatch.app", false},
+		{"https://*.preview.astromatch.app", "https://pr-42.preview.astromatch.app.evil.com", false},
+		{"https://*.preview.astromatch.app", "https://pr-42.preview.astromatch.app:8443", false},
+		{"https://pr-*.preview.astromatch.app", "https://pr-7.preview.astromatch.app", true},
+	}
+	for _, tt := range tests {
+		p, err := parseOrigin(tt.pattern)
+		if err != nil {
+			if tt.want {
+				t.Errorf("parseOrigin(%q): %v", tt.pattern, err)
+			}
+			continue
+		}
+		if got := p.matches(tt.origin); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestParseOriginRejects(t *testing.T) {
+	for _, origin := range []string{
+		"astromatch.app",
+		"ftp://astromatch.app",
+		"https://astromatch.app/",
+		"https://astromatch.app/path",
+		"https://user@astromatch.app",
+		"https://*.*.astromatch.app",
+		"https://astromatch.app:*",
+	} {
+		if _, err := parseOrigin(origin); err == nil {
+			t.Errorf("parseOrigin(%q) succeeded", origin)
+		}
+	}
+}
+
+func corsResponse(t *testing.T, cfg CORSConfig, origin string) http.Header {
+	t.Helper()
+	t.Cleanup(func() { InitCORS(CORSConfig{}) })
+	InitCORS(cfg)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	r.Header.Set("Origin", origin)
+	w := httptest.NewRecorder()
+	CORSMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+	return w.Header()
+}
+
+func TestCORSCredentials(t *testing.T) {
+	header := corsResponse(t, CORSConfig{AllowedOrigins: []string{"https://*.preview.astromatch.app"}, AllowCredentials: true}, "https://PR-1.preview.astromatch.app")
+	if got := header.Get("Access-Control-Allow-Origin"); got != "https://PR-1.preview.astromatch.app" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+
+	// Even if configured, * must never come with credentials
+	header = corsResponse(t, CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "https://evil.example")
+	if got := header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := header.Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q with any origin allowed", got)
+	}
+
+	header = corsResponse(t, CORSConfig{AllowedOrigins: []string{"https://astromatch.app"}, AllowCredentials: true}, "https://astromatch.app:8443")
+	if got := header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("origin on another port allowed: %q", got)
+	}
+}
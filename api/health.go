@@ -0,0 +1,101 @@
+package api
+
+import (
+	"astromatch/cache"
+	"astromatch/db"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds each dependency check
+const readinessTimeout = 2 * time.Second
+
+// dependencyCheck pings a single dependency
+type dependencyCheck func(ctx context.Context) error
+
+// readinessChecks lists the dependencies an instance needs to serve traffic
+var readinessChecks = map[string]dependencyCheck{
+	"mongodb": db.Ping,
+	"redis":   cache.Ping,
+}
+
+// shuttingDown is set once the server starts shutting down
+var shuttingDown atomic.Bool
+
+// BeginShutdown makes /readyz fail so load balancers stop routing new requests
+// here while the ones in flight drain
+func BeginShutdown() {
+	shuttingDown.Store(true)
+}
+
+// DependencyStatus reports the health of one dependency. The probe is
+// unauthenticated, so failure details are only logged.
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// HealthzHandler reports that the process is alive
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler pings every dependency and returns 503 when any is down or the
+// server is shutting down
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if shuttingDown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "shutting_down"})
+		return
+	}
+
+	results := make(map[string]DependencyStatus, len(readinessChecks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range readinessChecks {
+		wg.Add(1)
+		go func(name string, check dependencyCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+			status := DependencyStatus{
+				Status:    "up",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				status.Status = "down"
+				log.Printf("Readiness: %s is down: %v", name, err)
+			}
+
+			mu.Lock()
+			results[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	overall, code := "ok", http.StatusOK
+	for _, status := range results {
+		if status.Status != "up" {
+			overall, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       overall,
+		"dependencies": results,
+	})
+}
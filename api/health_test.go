@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useReadinessChecks(t *testing.T, checks map[string]dependencyCheck) {
+	t.Helper()
+	old := readinessChecks
+	t.Cleanup(func() { readinessChecks = old })
+	readinessChecks = checks
+}
+
+func TestReadyzHidesErrors(t *testing.T) {
+	useReadinessChecks(t, map[string]dependencyCheck{
+		"mongodb": func(ctx context.Context) error { return errors.New("dial tcp 10.0.3.7:27017: connection refused") },
+		"redis":   func(ctx context.Context) error { return nil },
+	})
+
+	w := httptest.NewRecorder()
+	ReadyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "10.0.3.7") {
+		t.Errorf("response leaks the driver error: %s", w.Body)
+	}
+	var body struct {
+		Status       string                      `json:"status"`
+		Dependencies map[string]DependencyStatus `json:"dependencies"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Dependencies["mongodb"].Status != "down" || body.Dependencies["redis"].Status != "up" {
+		t.Errorf("dependencies: %+v", body.Dependencies)
+	}
+}
+
+func TestReadyzFailsDuringShutdown(t *testing.T) {
+	useReadinessChecks(t, map[string]dependencyCheck{
+		"mongodb": func(ctx context.Context) error { return nil },
+	})
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	w := httptest.NewRecorder()
+	ReadyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("before shutdown: status %d, want 200", w.Code)
+	}
+
+	BeginShutdown()
+	w = httptest.NewRecorder()
+	ReadyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("during shutdown: status %d, want 503", w.Code)
+	}
+}
@@ -2,52 +2,83 @@ package api
 
 import (
 	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/respond"
+	"errors"
 	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
 )
 
-// AuthMiddleware for JWT validation
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// validRequestID limits client-supplied request IDs to safe, log-friendly values
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware tags every request with an ID, reusing a valid incoming
+// X-Request-ID, and echoes it in the response so clients can quote it in reports
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(respond.WithRequestID(r.Context(), id)))
+	})
+}
+
+// VerifiedMiddleware admits only accounts with a verified email or phone number;
+// it goes inside AuthMiddleware. Others are told to finish verification, which
+// POST /api/auth/resend-verification restarts.
+func VerifiedMiddleware(users db.UserRepository, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Enable CORS for every request
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		// Handle CORS preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		claims, ok := auth.ClaimsFromContext(r.Context())
+		if !ok {
+			respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
 			return
 		}
-
-		// Proceed with auth validation for non-preflight requests
-		cookie, err := r.Cookie("token")
+		user, err := users.GetByID(r.Context(), claims.UserID)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			respond.DBError(w, r, err, "Failed to load user")
 			return
 		}
-
-		_, err = auth.ValidateJWT(cookie.Value)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		if !user.IsVerified {
+			respond.Error(w, r, http.StatusForbidden, respond.CodeVerificationRequired, "Verify your email or phone number to continue")
 			return
 		}
-
-		next.ServeHTTP(w, r)
+		next(w, r)
 	}
 }
 
-// CORS Middleware for unauthenticated routes (like signup)
-func CORSMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// AuthMiddleware requires a valid session token for an account that still exists
+// and is not awaiting deletion, so deleting an account also ends the sessions on
+// its other devices. CORSMiddleware has already answered preflight requests,
+// which carry no cookie.
+func AuthMiddleware(users db.UserRepository, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		cookie, err := r.Cookie(auth.SessionCookie)
+		if err != nil {
+			respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+			return
+		}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		claims, err := auth.ValidateJWT(cookie.Value)
+		if err != nil {
+			respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidToken, "Invalid token")
+			return
+		}
+
+		user, err := users.GetByID(r.Context(), claims.UserID)
+		if errors.Is(err, db.ErrUserNotFound) || (err == nil && user.DeletedAt != nil) {
+			auth.EndSession(w)
+			respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidToken, "Invalid token")
+			return
+		}
+		if err != nil {
+			respond.DBError(w, r, err, "Failed to load user")
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 	}
 }
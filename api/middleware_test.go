@@ -0,0 +1,88 @@
+package api
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/db/memory"
+	"astromatch/respond"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthMiddlewareRejectsDeletedAccounts(t *testing.T) {
+	auth.Init(auth.Config{JWTSecret: "test-secret"})
+	ctx := context.Background()
+	deletedAt := time.Now().UTC()
+	users := memory.NewUserRepository()
+	users.Create(ctx, db.User{ID: "active"})
+	users.Create(ctx, db.User{ID: "deleted", DeletedAt: &deletedAt})
+
+	handler := AuthMiddleware(users, func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := auth.ClaimsFromContext(r.Context())
+		w.Write([]byte(claims.UserID))
+	})
+
+	tests := []struct {
+		userID string
+		want   int
+	}{
+		{"active", http.StatusOK},
+		{"deleted", http.StatusUnauthorized},
+		{"purged", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		token, err := auth.GenerateJWT(tt.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: token})
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.userID, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && len(w.Result().Cookies()) == 0 {
+			t.Errorf("%s: session cookie was not cleared", tt.userID)
+		}
+	}
+}
+
+func TestMatchRequiresVerifiedAccount(t *testing.T) {
+	auth.Init(auth.Config{JWTSecret: "test-secret"})
+	ctx := context.Background()
+	store := memory.NewStore()
+	store.Users.Create(ctx, db.User{ID: "pending", ZodiacSign: "Leo"})
+	store.Users.Create(ctx, db.User{ID: "verified", ZodiacSign: "Leo", IsVerified: true})
+	mux := http.NewServeMux()
+	SetupRoutes(mux, store)
+
+	find := func(userID string) *httptest.ResponseRecorder {
+		token, err := auth.GenerateJWT(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/api/match/find", nil)
+		r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: token})
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := find("pending")
+	var body respond.Envelope
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusForbidden || body.Error.Code != respond.CodeVerificationRequired {
+		t.Errorf("unverified caller: status %d, code %q", w.Code, body.Error.Code)
+	}
+
+	if w := find("verified"); w.Code != http.StatusOK {
+		t.Errorf("verified caller: status %d, body %s", w.Code, w.Body)
+	}
+}
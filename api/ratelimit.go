@@ -0,0 +1,215 @@
+package api
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/ratelimit"
+	"astromatch/respond"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLimitedBody bounds how much of a body is read to find its identifier
+const maxLimitedBody = 1 << 20
+
+// RateLimitMiddleware limits requests to route per client IP and per account
+// identifier in the JSON body, answering 429 with Retry-After once a limit is hit
+func RateLimitMiddleware(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ratelimit.Enabled() {
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		perIP, perIdentifier := ratelimit.Limits(route)
+
+		if ok, wait := ratelimit.Allow(ctx, ratelimit.IPKey(route, clientIP(r)), perIP); !ok {
+			tooManyRequests(w, r, wait, respond.CodeRateLimited, "Too many requests, please try again later")
+			return
+		}
+		if identifier := requestIdentifier(r); identifier != "" {
+			if ok, wait := ratelimit.Allow(ctx, ratelimit.IdentifierKey(route, identifier), perIdentifier); !ok {
+				tooManyRequests(w, r, wait, respond.CodeRateLimited, "Too many attempts, please try again later")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// LockoutMiddleware locks an account out of login after repeated failures, for
+// longer with each further failure. Only a login that starts a session clears the
+// count, so passing the password while failing the second factor keeps it.
+// Password and second-factor failures count against the same account.
+func LockoutMiddleware(users db.UserRepository, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ratelimit.Enabled() {
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		identifier := lockoutIdentifier(ctx, users, parseIdentifiers(r))
+		if identifier == "" {
+			next(w, r)
+			return
+		}
+		if wait := ratelimit.LockedFor(ctx, identifier); wait > 0 {
+			tooManyRequests(w, r, wait, respond.CodeAccountLocked, "Too many failed logins, please try again later")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		switch {
+		case rec.status == http.StatusUnauthorized:
+			if wait := ratelimit.RecordFailure(ctx, identifier); wait > 0 {
+				log.Printf("Login for %s locked for %s after repeated failures", identifier, wait.Round(time.Second))
+			}
+		case rec.status < http.StatusMultipleChoices && startedSession(rec.Header()):
+			ratelimit.ResetFailures(ctx, identifier)
+		}
+	}
+}
+
+// lockoutIdentifier keys the lockout by the account the request is for. An email
+// or phone number without an account is locked by itself, so failures look the
+// same whether or not the account exists.
+func lockoutIdentifier(ctx context.Context, users db.UserRepository, ids requestIdentifiers) string {
+	var user db.User
+	var err error
+	switch {
+	case ids.email != "":
+		user, err = users.GetByEmail(ctx, ids.email)
+	case ids.phone != "":
+		user, err = users.GetByPhone(ctx, ids.phone)
+	default:
+		return ids.String()
+	}
+	if err != nil {
+		if !errors.Is(err, db.ErrUserNotFound) {
+			log.Printf("Lockout falls back to the identifier: %v", err)
+		}
+		return ids.String()
+	}
+	return userIdentifier(user.ID)
+}
+
+// startedSession reports whether a response sets the session cookie
+func startedSession(header http.Header) bool {
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.Name == auth.SessionCookie && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// tooManyRequests answers 429, telling the client when to retry
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, code, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respond.Error(w, r, http.StatusTooManyRequests, code, message)
+}
+
+// clientIP is the caller's address: the X-Forwarded-For entry our proxy added
+// when it is trusted, else the connection's
+func clientIP(r *http.Request) string {
+	if ratelimit.TrustProxy() {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestIdentifiers are the ways a JSON body names an account
+type requestIdentifiers struct {
+	email, phone string
+	// userID is who a valid MFA challenge in the body was issued to
+	userID string
+}
+
+// String returns the identifier to limit by: the email or phone number, or the
+// user of an MFA challenge. Challenges are keyed by user because every login
+// issues a new one.
+func (ids requestIdentifiers) String() string {
+	switch {
+	case ids.email != "":
+		return ids.email
+	case ids.phone != "":
+		return ids.phone
+	case ids.userID != "":
+		return userIdentifier(ids.userID)
+	}
+	return ""
+}
+
+func userIdentifier(userID string) string {
+	return "user:" + userID
+}
+
+// requestIdentifier returns the identifier a JSON body names, leaving the body for
+// the handler to read
+func requestIdentifier(r *http.Request) string {
+	return parseIdentifiers(r).String()
+}
+
+// parseIdentifiers reads the email, phone number and MFA challenge from a JSON
+// body, leaving the body for the handler to read
+func parseIdentifiers(r *http.Request) requestIdentifiers {
+	if r.Body == nil {
+		return requestIdentifiers{}
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLimitedBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return requestIdentifiers{}
+	}
+
+	var fields struct {
+		Email    string `json:"email"`
+		Phone    string `json:"phone"`
+		MFAToken string `json:"mfaToken"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return requestIdentifiers{}
+	}
+	return requestIdentifiers{
+		email:  strings.TrimSpace(fields.Email),
+		phone:  strings.TrimSpace(fields.Phone),
+		userID: auth.MFAChallengeUserID(strings.TrimSpace(fields.MFAToken)),
+	}
+}
+
+// statusRecorder remembers the status a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/db/memory"
+	"astromatch/ratelimit"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newLockoutTest enables a lockout after three failures and returns a store
+// holding users with the password "correct horse"
+func newLockoutTest(t *testing.T, users ...db.User) *db.Store {
+	t.Helper()
+	auth.Init(auth.Config{JWTSecret: "test-secret"})
+	ratelimit.Init(ratelimit.Config{
+		Enabled:          true,
+		LockoutThreshold: 3,
+		LockoutWindow:    time.Hour,
+		LockoutDuration:  time.Minute,
+	})
+	t.Cleanup(func() { ratelimit.Init(ratelimit.Config{}) })
+
+	hash, err := auth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.NewStore()
+	for _, u := range users {
+		u.Password = hash
+		if err := store.Users.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func post(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestLockoutSharedByPasswordAndSecondFactor(t *testing.T) {
+	store := newLockoutTest(t, db.User{ID: "mfa-user", Email: "mfa@example.com", MFA: &db.MFA{Enabled: true}})
+	h := auth.NewHandler(store)
+	login := LockoutMiddleware(store.Users, h.Login)
+	verify := LockoutMiddleware(store.Users, h.VerifyMFA)
+
+	if w := post(login, `{"email":"mfa@example.com","password":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d", w.Code)
+	}
+	w := post(login, `{"email":"mfa@example.com","password":"correct horse"}`)
+	var challenge struct {
+		MFAToken string `json:"mfaToken"`
+	}
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&challenge) != nil || challenge.MFAToken == "" {
+		t.Fatalf("right password: status %d, no challenge", w.Code)
+	}
+
+	wrongCode := `{"mfaToken":"` + challenge.MFAToken + `","recoveryCode":"nope"}`
+	for i := 0; i < 2; i++ {
+		if w := post(verify, wrongCode); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong second factor %d: status %d", i, w.Code)
+		}
+	}
+
+	// One password failure and two second-factor failures reach the threshold
+	w = post(login, `{"email":"mfa@example.com","password":"correct horse"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("login after three failures: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := post(verify, wrongCode); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second factor after three failures: status %d", w.Code)
+	}
+}
+
+func TestLockoutClearedBySession(t *testing.T) {
+	store := newLockoutTest(t, db.User{ID: "plain-user", Email: "plain@example.com"})
+	login := LockoutMiddleware(store.Users, auth.NewHandler(store).Login)
+
+	fail := func() int { return post(login, `{"email":"plain@example.com","password":"wrong"}`).Code }
+	fail()
+	fail()
+	if w := post(login, `{"email":"plain@example.com","password":"correct horse"}`); w.Code != http.StatusOK {
+		t.Fatalf("login: status %d", w.Code)
+	}
+	fail()
+	fail()
+	if code := fail(); code != http.StatusUnauthorized {
+		t.Errorf("failures before the session still counted: status %d", code)
+	}
+	if code := fail(); code != http.StatusTooManyRequests {
+		t.Errorf("fourth failure after the session: status %d, want 429", code)
+	}
+}
+
+func TestLockoutUnknownEmail(t *testing.T) {
+	store := newLockoutTest(t)
+	login := LockoutMiddleware(store.Users, auth.NewHandler(store).Login)
+
+	for i := 0; i < 3; i++ {
+		if w := post(login, `{"email":"nobody@example.com","password":"x"}`); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status %d", i, w.Code)
+		}
+	}
+	if w := post(login, `{"email":"nobody@example.com","password":"x"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("unknown email is not locked like a real one: status %d", w.Code)
+	}
+}
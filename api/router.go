@@ -1,20 +1,61 @@
 package api
 
 import (
+	"astromatch/account"
 	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/lifecycle"
 	"astromatch/matchmaking"
+	"astromatch/notify"
+	"astromatch/photos"
+	"astromatch/ratelimit"
 	"astromatch/user"
 	"net/http"
 )
 
-func SetupRoutes() {
-	//unprotected routes with middleware
-	http.HandleFunc("/api/auth/login", CORSMiddleware(auth.LoginHandler))
-	http.HandleFunc("/api/auth/signup", CORSMiddleware(auth.SignupHandler))
-	http.HandleFunc("/api/auth/verify-otp", CORSMiddleware(auth.VerifyUserHandler))
+// Connections tracks long-lived connections (WebSockets) so shutdown can drain them.
+// Handlers that hijack a connection register it with Track and release it when done.
+var Connections = lifecycle.NewConnections()
+
+// SetupRoutes registers every API route on mux, backed by store
+func SetupRoutes(mux *http.ServeMux, store *db.Store) {
+	authHandler := auth.NewHandler(store)
+	userHandler := user.NewHandler(store)
+	matchHandler := matchmaking.NewHandler(store)
+	photoHandler := photos.NewHandler(store)
+	accountHandler := account.NewHandler(store)
+
+	//health probes
+	mux.HandleFunc("/healthz", HealthzHandler)
+	mux.HandleFunc("/readyz", ReadyzHandler)
+
+	//unprotected routes
+	mux.HandleFunc("/api/auth/login", RateLimitMiddleware(ratelimit.RouteLogin, LockoutMiddleware(store.Users, authHandler.Login)))
+	mux.HandleFunc("/api/auth/signup", RateLimitMiddleware(ratelimit.RouteSignup, authHandler.Signup))
+	mux.HandleFunc("/api/auth/verify-otp", RateLimitMiddleware(ratelimit.RouteVerifyOTP, authHandler.VerifyUser))
+	mux.HandleFunc("/api/auth/resend-verification", RateLimitMiddleware(ratelimit.RouteResendVerification, authHandler.ResendVerification))
+	mux.HandleFunc("/api/auth/mfa/verify", RateLimitMiddleware(ratelimit.RouteMFA, LockoutMiddleware(store.Users, authHandler.VerifyMFA)))
+	mux.HandleFunc("/api/auth/passkeys/login/begin", RateLimitMiddleware(ratelimit.RoutePasskeyLogin, authHandler.BeginPasskeyLogin))
+	mux.HandleFunc("/api/auth/passkeys/login/finish", RateLimitMiddleware(ratelimit.RoutePasskeyLogin, authHandler.FinishPasskeyLogin))
+	mux.HandleFunc("/api/dev/email-preview", notify.PreviewHandler)
 
 	//protected routes with auth
-	http.HandleFunc("/api/match/find", AuthMiddleware(matchmaking.MatchHandler))
-	http.HandleFunc("/api/v1/users", AuthMiddleware(user.GetOrUpdateProfile))
+	mux.HandleFunc("/api/match/find", AuthMiddleware(store.Users, VerifiedMiddleware(store.Users, matchHandler.Match)))
+	mux.HandleFunc("/api/v1/users", AuthMiddleware(store.Users, userHandler.GetOrUpdateProfile))
+	mux.HandleFunc("/api/v1/users/me", AuthMiddleware(store.Users, accountHandler.Me))
+	mux.HandleFunc("/api/v1/users/me/export", AuthMiddleware(store.Users, accountHandler.Export))
+	mux.HandleFunc("/api/v1/users/me/identities", AuthMiddleware(store.Users, authHandler.Identities))
+	mux.HandleFunc("/api/v1/users/me/identities/{provider}/{subject}", AuthMiddleware(store.Users, authHandler.Identity))
+	mux.HandleFunc("/api/v1/users/me/mfa", AuthMiddleware(store.Users, authHandler.MFA))
+	mux.HandleFunc("/api/v1/users/me/mfa/totp", AuthMiddleware(store.Users, authHandler.EnrollTOTP))
+	mux.HandleFunc("/api/v1/users/me/mfa/totp/confirm", AuthMiddleware(store.Users, authHandler.ConfirmTOTP))
+	mux.HandleFunc("/api/v1/users/me/mfa/recovery-codes", AuthMiddleware(store.Users, authHandler.RecoveryCodes))
+	mux.HandleFunc("/api/v1/users/me/passkeys", AuthMiddleware(store.Users, authHandler.Passkeys))
+	mux.HandleFunc("/api/v1/users/me/passkeys/{passkeyID}", AuthMiddleware(store.Users, authHandler.Passkey))
+	mux.HandleFunc("/api/v1/users/me/passkeys/register/begin", AuthMiddleware(store.Users, authHandler.BeginPasskeyRegistration))
+	mux.HandleFunc("/api/v1/users/me/passkeys/register/finish", AuthMiddleware(store.Users, authHandler.FinishPasskeyRegistration))
+	mux.HandleFunc("/api/v1/users/me/preferences", AuthMiddleware(store.Users, userHandler.UpdatePreferences))
+	mux.HandleFunc("/api/v1/users/me/photos", AuthMiddleware(store.Users, photoHandler.Photos))
+	mux.HandleFunc("/api/v1/users/me/photos/{photoID}", AuthMiddleware(store.Users, photoHandler.Photo))
 
 }
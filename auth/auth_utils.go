@@ -1,25 +1,114 @@
 package auth
 
 import (
-	"astromatch/db"
-	"encoding/json"
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
-	"io"
-	"log"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
-	"github.com/huandu/facebook/v2"
 	"golang.org/x/crypto/bcrypt"
-	"google.golang.org/api/idtoken"
 )
 
-var jwtKey = []byte("supersecretkey")
+// Config holds authentication secrets and provider settings
+type Config struct {
+	JWTSecret string `yaml:"jwtSecret" json:"jwtSecret" env:"JWT_SECRET"`
+	// GoogleClientIDs lists every OAuth client (web, iOS, Android) Google ID tokens may be issued to
+	GoogleClientIDs []string `yaml:"googleClientIds" json:"googleClientIds" env:"GOOGLE_CLIENT_IDS"`
+	// GoogleCertsURL is where Google's signing keys are fetched from
+	GoogleCertsURL string `yaml:"googleCertsUrl" json:"googleCertsUrl" env:"GOOGLE_CERTS_URL"`
+	// AppleClientIDs are the app bundle IDs and services IDs Sign in with Apple tokens
+	// may be issued to; Apple sign-in is off when empty
+	AppleClientIDs []string `yaml:"appleClientIds" json:"appleClientIds" env:"APPLE_CLIENT_IDS"`
+	// AppleKeysURL is where Apple's signing keys are fetched from
+	AppleKeysURL string `yaml:"appleKeysUrl" json:"appleKeysUrl" env:"APPLE_KEYS_URL"`
+	// FacebookAppID and FacebookAppSecret identify our Facebook app; Facebook
+	// sign-in is off when they are empty
+	FacebookAppID     string `yaml:"facebookAppId" json:"facebookAppId" env:"FACEBOOK_APP_ID"`
+	FacebookAppSecret string `yaml:"facebookAppSecret" json:"facebookAppSecret" env:"FACEBOOK_APP_SECRET"`
+	// FacebookGraphURL is the Graph API base URL
+	FacebookGraphURL string `yaml:"facebookGraphUrl" json:"facebookGraphUrl" env:"FACEBOOK_GRAPH_URL"`
+	// WebAuthnRPID is the passkey relying party ID, the site's domain; passkeys are
+	// off when empty
+	WebAuthnRPID string `yaml:"webauthnRpId" json:"webauthnRpId" env:"WEBAUTHN_RP_ID"`
+	// WebAuthnRPName is the site name authenticators show
+	WebAuthnRPName string `yaml:"webauthnRpName" json:"webauthnRpName" env:"WEBAUTHN_RP_NAME"`
+	// WebAuthnOrigins are the web and app origins passkey ceremonies may come from
+	WebAuthnOrigins []string `yaml:"webauthnOrigins" json:"webauthnOrigins" env:"WEBAUTHN_ORIGINS"`
+	// OIDCProviders are partner SSO providers, configured in the config file only
+	OIDCProviders []OIDCConfig `yaml:"oidcProviders" json:"oidcProviders"`
+	// MFAEncryptionKey is the base64 encoded 32 byte key TOTP secrets are encrypted with
+	MFAEncryptionKey string `yaml:"mfaEncryptionKey" json:"mfaEncryptionKey" env:"MFA_ENCRYPTION_KEY"`
+	// MFAIssuer names the service in authenticator apps
+	MFAIssuer string `yaml:"mfaIssuer" json:"mfaIssuer" env:"MFA_ISSUER"`
+	// CookieSameSite is the session cookie's SameSite attribute: lax, strict or none.
+	// None, which other sites' pages need to send the cookie, implies CookieSecure.
+	CookieSameSite string `yaml:"cookieSameSite" json:"cookieSameSite" env:"SESSION_COOKIE_SAMESITE"`
+	// CookieSecure keeps the session cookie to HTTPS
+	CookieSecure bool `yaml:"cookieSecure" json:"cookieSecure" env:"SESSION_COOKIE_SECURE"`
+}
+
+var (
+	jwtKey []byte
+	// otpKey is derived from the JWT secret, so OTP hashes and tokens never share a key
+	otpKey    []byte
+	mfaKey    []byte
+	mfaIssuer = "AstroMatch"
+	// Session cookie attributes
+	cookieSameSite = http.SameSiteLaxMode
+	cookieSecure   bool
+)
+
+// Init applies the authentication configuration and registers the sign-in providers it enables
+func Init(cfg Config) {
+	jwtKey = []byte(cfg.JWTSecret)
+	otpKey = deriveKey(jwtKey, "otp")
+	// Validated by config; a bad key leaves two-factor enrollment unavailable
+	mfaKey, _ = DecodeMFAKey(cfg.MFAEncryptionKey)
+	if cfg.MFAIssuer != "" {
+		mfaIssuer = cfg.MFAIssuer
+	}
+	initSessionCookie(cfg)
+	registerProviders(cfg)
+	initWebAuthn(cfg)
+}
+
+// initSessionCookie applies the session cookie attributes. The SameSite value is
+// validated by config; one that does not parse leaves the cookie lax.
+func initSessionCookie(cfg Config) {
+	cookieSameSite, _ = ParseSameSite(cfg.CookieSameSite)
+	// Browsers drop SameSite=None cookies that are not Secure
+	cookieSecure = cfg.CookieSecure || cookieSameSite == http.SameSiteNoneMode
+}
+
+// ParseSameSite maps a CookieSameSite setting to its attribute; empty means lax
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return http.SameSiteLaxMode, fmt.Errorf("%q is not one of lax, strict, none", value)
+}
+
+// deriveKey returns a key for purpose from secret
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
 
 // Claims struct for JWT
 type Claims struct {
 	UserID string `json:"user_id"`
+	// Purpose marks restricted tokens such as MFA challenges; session tokens have none
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -45,6 +134,9 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 	if err != nil || !token.Valid {
 		return nil, err
 	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not a session token")
+	}
 	return claims, nil
 }
 
@@ -60,88 +152,6 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-// VerifyGoogleToken verifies Google OAuth token
-func VerifyGoogleToken(r *http.Request) (db.User, error) {
-	var req struct {
-		Token string `json:"token"`
-	}
-
-	// json.NewDecoder(r.Body).Decode(&req)
-	// err := json.NewDecoder(r.Body).Decode(&req)
-	// if err != nil {
-	// 	log.Printf("Failed to decode request body: %v", err)
-	// 	return db.User{}, errors.New("invalid request body")
-	// }
-
-	// if req.Token == "" {
-	// 	log.Println("Missing Google ID token in request")
-	// 	return db.User{}, errors.New("missing Google token")
-	// }
-	// Read body only once
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Failed to read request body: %v", err)
-		return db.User{}, errors.New("unable to read request body")
-	}
-
-	// Log the raw request body for debugging
-	log.Printf("Raw Request Body: %s", string(body))
-
-	// Unmarshal JSON payload manually
-	err = json.Unmarshal(body, &req)
-	if err != nil {
-		log.Printf("Failed to decode request body: %v", err)
-		return db.User{}, errors.New("invalid request body format")
-	}
-
-	// Get Google Client ID from environment variables
-	clientID := "905964697703-a3smbmrtsnvcgpssqmdoh2stoietokc0.apps.googleusercontent.com"
-	if clientID == "" {
-		log.Println("Google Client ID is not set")
-		return db.User{}, errors.New("Google Client ID is missing")
-	}
-
-	// Validate the token
-	payload, err := idtoken.Validate(r.Context(), req.Token, clientID)
-	if err != nil {
-		log.Printf("Google token validation failed: %v", err)
-		return db.User{}, errors.New("invalid Google token")
-	}
-
-	// Extract email, name, and profile pic from the payload
-	email, _ := payload.Claims["email"].(string)
-	name, _ := payload.Claims["name"].(string)
-	picture, _ := payload.Claims["picture"].(string)
-
-	return db.User{
-		Email:        email,
-		Name:         name,
-		ProfilePic:   picture,
-		SignupMethod: "google",
-	}, nil
-}
-
-// VerifyFacebookToken verifies Facebook OAuth token
-func VerifyFacebookToken(r *http.Request) (db.User, error) {
-	var req struct {
-		Token string `json:"token"`
-	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	res, err := facebook.Get("/me", facebook.Params{
-		"fields":       "id,name,email",
-		"access_token": req.Token,
-	})
-	if err != nil {
-		return db.User{}, errors.New("invalid Facebook token")
-	}
-
-	return db.User{
-		Email: res.Get("email").(string),
-		Name:  res.Get("name").(string),
-	}, nil
-}
-
 // VerifyPhoneNumber simulates phone number verification
 func VerifyPhoneNumber(phone string) bool {
 	return len(phone) >= 10
@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"context"
+)
+
+type claimsKey struct{}
+
+// WithClaims stores validated JWT claims in ctx
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by the auth middleware
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
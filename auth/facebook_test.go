@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"astromatch/db"
+	"astromatch/db/memory"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	testFacebookApp    = "fb-app"
+	testFacebookSecret = "fb-secret"
+	testFacebookToken  = "user-token"
+)
+
+// fakeGraph stands in for the Graph API. tokenInfo answers debug_token and
+// profile answers /me; both may be changed by tests.
+type fakeGraph struct {
+	tokenInfo map[string]interface{}
+	profile   map[string]interface{}
+	// proofs are the appsecret_proof values sent with profile reads
+	proofs []string
+}
+
+func newFakeGraph(t *testing.T) (*fakeGraph, *FacebookProvider) {
+	t.Helper()
+	g := &fakeGraph{
+		tokenInfo: map[string]interface{}{"app_id": testFacebookApp, "user_id": "fb-1", "is_valid": true},
+		profile:   map[string]interface{}{"id": "fb-1", "name": "Ada", "email": "ada@example.com"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/debug_token"):
+			if query.Get("input_token") != testFacebookToken || query.Get("appsecret_proof") != "" {
+				http.Error(w, `{"error": {"message": "bad debug_token request"}}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": g.tokenInfo})
+		case strings.HasSuffix(r.URL.Path, "/me"):
+			g.proofs = append(g.proofs, query.Get("appsecret_proof"))
+			json.NewEncoder(w).Encode(g.profile)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return g, NewFacebookProvider(testFacebookApp, testFacebookSecret, server.URL)
+}
+
+func TestFacebookVerify(t *testing.T) {
+	g, p := newFakeGraph(t)
+
+	ext, err := p.Verify(context.Background(), testFacebookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ExternalIdentity{Provider: db.ProviderFacebook, Subject: "fb-1", Email: "ada@example.com", Name: "Ada"}
+	if ext != want {
+		t.Errorf("got %+v, want %+v", ext, want)
+	}
+
+	mac := hmac.New(sha256.New, []byte(testFacebookSecret))
+	mac.Write([]byte(testFacebookToken))
+	if len(g.proofs) != 1 || g.proofs[0] != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("profile read with appsecret_proof %q", g.proofs)
+	}
+}
+
+func TestFacebookRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*fakeGraph)
+		wantErr string
+	}{
+		{"invalid token", func(g *fakeGraph) { g.tokenInfo["is_valid"] = false }, "not valid"},
+		{"another app's token", func(g *fakeGraph) { g.tokenInfo["app_id"] = "other-app" }, "issued to app"},
+		{"no user", func(g *fakeGraph) { delete(g.tokenInfo, "user_id") }, "no user"},
+		{"profile of someone else", func(g *fakeGraph) { g.profile["id"] = "fb-2" }, "does not match"},
+	}
+	for _, tt := range tests {
+		g, p := newFakeGraph(t)
+		tt.modify(g)
+		_, err := p.Verify(context.Background(), testFacebookToken)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFacebookWithoutEmail(t *testing.T) {
+	g, p := newFakeGraph(t)
+	delete(g.profile, "email")
+
+	ext, err := p.Verify(context.Background(), testFacebookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Subject != "fb-1" || ext.Email != "" {
+		t.Fatalf("got %+v, want the Facebook ID without an email", ext)
+	}
+
+	h := NewHandler(memory.NewStore())
+	user, created, err := h.signInWithIdentity(context.Background(), ext)
+	if err != nil || !created || user.Email != "" {
+		t.Errorf("sign-in without email: %+v, created %v, %v", user, created, err)
+	}
+}
+
+func TestFacebookReturningUserLogsIn(t *testing.T) {
+	oldJWT := jwtKey
+	t.Cleanup(func() { jwtKey = oldJWT })
+	jwtKey = []byte("test-jwt-secret")
+
+	_, p := newFakeGraph(t)
+	h := NewHandler(memory.NewStore())
+	signup := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", nil)
+		w := httptest.NewRecorder()
+		h.handleProviderSignup(w, r, p, SignupRequest{Token: testFacebookToken, SignupMethod: db.ProviderFacebook})
+		return w
+	}
+
+	for _, want := range []string{"User registered successfully", "User logged in successfully"} {
+		w := signup()
+		var body map[string]string
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusOK || body["message"] != want || len(w.Result().Cookies()) != 1 {
+			t.Errorf("got status %d, %v; want a session and %q", w.Code, body, want)
+		}
+	}
+}
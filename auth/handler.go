@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"astromatch/db"
+	"time"
+)
+
+// Handler serves the authentication endpoints using the injected repositories
+type Handler struct {
+	store *db.Store
+	// now is the clock TOTP codes are checked against
+	now func() time.Time
+}
+
+// NewHandler creates auth handlers backed by store
+func NewHandler(store *db.Store) *Handler {
+	return &Handler{store: store, now: time.Now}
+}
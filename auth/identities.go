@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"astromatch/db"
+	"astromatch/respond"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LinkRequest links another login method to the caller's account. External
+// providers take the provider token; "email" adds a password login for the
+// account's verified email.
+type LinkRequest struct {
+	Provider string `json:"provider"`
+	Token    string `json:"token,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// minPasswordLength applies to passwords added through linking
+const minPasswordLength = 8
+
+// Identities lists (GET) or links (POST) the caller's login methods
+func (h *Handler) Identities(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.listIdentities(w, r, claims.UserID, http.StatusOK)
+	case http.MethodPost:
+		h.linkIdentity(w, r, claims.UserID)
+	default:
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// Identity unlinks (DELETE) one of the caller's login methods. The last one
+// cannot be removed, or the account could never be logged in to again.
+func (h *Handler) Identity(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := r.Context()
+	provider, subject := r.PathValue("provider"), r.PathValue("subject")
+
+	identities, err := h.store.Identities.ListByUser(ctx, claims.UserID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to unlink login")
+		return
+	}
+	owned := false
+	for _, identity := range identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			owned = true
+		}
+	}
+	if !owned {
+		respond.Error(w, r, http.StatusNotFound, respond.CodeNotFound, "Login method not found")
+		return
+	}
+	if len(identities) == 1 {
+		respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "You cannot remove your only login method")
+		return
+	}
+
+	if err := h.store.Identities.Delete(ctx, provider, subject); err != nil {
+		respond.DBError(w, r, err, "Failed to unlink login")
+		return
+	}
+	// Without its email identity the stored password must stop working too
+	if provider == db.ProviderEmail {
+		if _, err := h.store.Users.UpdateFields(ctx, claims.UserID, map[string]interface{}{db.FieldPassword: nil}, db.AnyVersion); err != nil {
+			respond.DBError(w, r, err, "Failed to unlink login")
+			return
+		}
+	}
+
+	log.Printf("Unlinked %s login from %s", provider, claims.UserID)
+	h.listIdentities(w, r, claims.UserID, http.StatusOK)
+}
+
+func (h *Handler) linkIdentity(w http.ResponseWriter, r *http.Request, userID string) {
+	var req LinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	var err error
+	if req.Provider == db.ProviderEmail {
+		err = h.linkPassword(r, userID, req)
+	} else if provider, ok := lookupProvider(req.Provider); ok {
+		err = h.linkExternal(r, userID, provider, req.Token)
+	} else {
+		verr := &db.ValidationError{}
+		verr.Add("provider", "must be one of "+strings.Join(append(providerNames(), db.ProviderEmail), ", "))
+		err = verr
+	}
+
+	var tokenErr invalidTokenError
+	if errors.As(err, &tokenErr) {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidToken, tokenErr.Error())
+		return
+	}
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to link login")
+		return
+	}
+
+	log.Printf("Linked %s login to %s", req.Provider, userID)
+	h.listIdentities(w, r, userID, http.StatusCreated)
+}
+
+// invalidTokenError is a provider token that failed verification
+type invalidTokenError struct{ provider string }
+
+func (e invalidTokenError) Error() string { return "Invalid " + e.provider + " token" }
+
+// linkExternal links the provider account behind token. Linking an account that
+// is already yours again is a no-op; one linked elsewhere is a conflict.
+func (h *Handler) linkExternal(r *http.Request, userID string, provider IdentityProvider, token string) error {
+	ext, err := provider.Verify(r.Context(), token)
+	if err != nil {
+		log.Printf("Link token verification failed: %v", err)
+		return invalidTokenError{provider: provider.Name()}
+	}
+
+	existing, err := h.store.Identities.Find(r.Context(), ext.Provider, ext.Subject)
+	switch {
+	case err == nil && existing.UserID == userID:
+		return nil
+	case err == nil:
+		return db.ErrDuplicateIdentity
+	case !errors.Is(err, db.ErrIdentityNotFound):
+		return err
+	}
+	return h.link(r.Context(), userID, ext)
+}
+
+// linkPassword adds an email and password login for the account's email, which a
+// provider or OTP must already have verified
+func (h *Handler) linkPassword(r *http.Request, userID string, req LinkRequest) error {
+	ctx := r.Context()
+	if len(req.Password) < minPasswordLength {
+		verr := &db.ValidationError{}
+		verr.Add("password", "must be at least 8 characters")
+		return verr
+	}
+
+	user, err := h.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	owner, err := h.store.Identities.FindVerifiedByEmail(ctx, user.Email)
+	if errors.Is(err, db.ErrIdentityNotFound) || (err == nil && owner.UserID != userID) {
+		return db.Conflict("your account has no verified email to log in with")
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	err = h.store.Identities.Create(ctx, db.Identity{
+		UserID:   userID,
+		Provider: db.ProviderEmail,
+		Subject:  user.Email,
+		Email:    user.Email,
+		Verified: true,
+		LinkedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.store.Users.UpdateFields(ctx, userID, map[string]interface{}{db.FieldPassword: hash}, db.AnyVersion)
+	return err
+}
+
+func (h *Handler) listIdentities(w http.ResponseWriter, r *http.Request, userID string, status int) {
+	identities, err := h.store.Identities.ListByUser(r.Context(), userID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to list logins")
+		return
+	}
+	if identities == nil {
+		identities = []db.Identity{}
+	}
+	respond.JSON(w, status, identities)
+}
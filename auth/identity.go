@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"astromatch/db"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExternalIdentity is a person as asserted by an OAuth provider
+type ExternalIdentity struct {
+	Provider string
+	Subject  string
+	Email    string
+	// EmailVerified is true only when the provider vouches for Email
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// errAccountExists means an account already uses the email but it cannot be
+// proven to belong to the same person, so the user has to log in and link
+var errAccountExists = db.Conflict("an account with this email already exists; log in to it and link this sign-in method")
+
+// signInWithIdentity returns the account ext logs in to, creating or linking one
+// when needed. Linking to an existing account happens only through an email the
+// provider verified and that the account has verified too, so nobody can take
+// over an account by registering the victim's address with a provider.
+func (h *Handler) signInWithIdentity(ctx context.Context, ext ExternalIdentity) (user db.User, created bool, err error) {
+	identity, err := h.store.Identities.Find(ctx, ext.Provider, ext.Subject)
+	if err == nil {
+		user, err = h.store.Users.GetByID(ctx, identity.UserID)
+		if err != nil {
+			return db.User{}, false, err
+		}
+		user, err = h.markVerified(ctx, user)
+		return user, false, err
+	}
+	if !errors.Is(err, db.ErrIdentityNotFound) {
+		return db.User{}, false, err
+	}
+
+	if ext.Email != "" {
+		user, err = h.accountForEmail(ctx, ext)
+		if err == nil {
+			if err := h.link(ctx, user.ID, ext); err != nil {
+				return db.User{}, false, err
+			}
+			log.Printf("Linked %s sign-in to existing account %s", ext.Provider, user.ID)
+			user, err = h.markVerified(ctx, user)
+			return user, false, err
+		}
+		if !errors.Is(err, db.ErrUserNotFound) {
+			return db.User{}, false, err
+		}
+	}
+
+	user = db.User{
+		ID:           uuid.New().String(),
+		Name:         ext.Name,
+		ProfilePic:   ext.Picture,
+		SignupMethod: ext.Provider,
+		// The provider authenticated the person, which is the verification an
+		// email or phone signup gets from its OTP. It does not prove the email.
+		IsVerified: true,
+	}
+	// An address nobody proved stays on the identity, so it cannot take the
+	// unique email slot from its real owner
+	if ext.EmailVerified {
+		user.Email = ext.Email
+	}
+	if err := h.store.Users.Create(ctx, user); err != nil {
+		if errors.Is(err, db.ErrDuplicateUser) {
+			return db.User{}, false, errAccountExists
+		}
+		return db.User{}, false, err
+	}
+	if err := h.link(ctx, user.ID, ext); err != nil {
+		return db.User{}, false, err
+	}
+	return user, true, nil
+}
+
+// accountForEmail finds the account ext may be linked to automatically. It returns
+// db.ErrUserNotFound when there is none, including once an unverified account has
+// given the email up, and errAccountExists when an account uses the email but
+// linking would not be safe.
+func (h *Handler) accountForEmail(ctx context.Context, ext ExternalIdentity) (db.User, error) {
+	existing, err := h.store.Users.GetByEmail(ctx, ext.Email)
+	if err != nil {
+		return db.User{}, err
+	}
+	if !ext.EmailVerified {
+		return db.User{}, errAccountExists
+	}
+
+	// The account proved it owns the email through OTP or another provider
+	owner, err := h.store.Identities.FindVerifiedByEmail(ctx, ext.Email)
+	if err == nil && owner.UserID == existing.ID {
+		return existing, nil
+	}
+	if err != nil && !errors.Is(err, db.ErrIdentityNotFound) {
+		return db.User{}, err
+	}
+
+	// Accounts created through this provider before identities were stored
+	if existing.SignupMethod == ext.Provider {
+		return existing, nil
+	}
+
+	// A signup that never proved the address may be someone else's squatting on
+	// it. The provider's proof wins: the address leaves that account, and the
+	// caller creates a new one for its owner.
+	if !existing.IsVerified && owner.UserID == "" {
+		if err := h.releaseEmail(ctx, existing, ext.Provider); err != nil {
+			return db.User{}, err
+		}
+		return db.User{}, db.ErrUserNotFound
+	}
+	return db.User{}, errAccountExists
+}
+
+// releaseEmail removes user's unverified email along with the password login,
+// email identity and pending OTPs that came with it
+func (h *Handler) releaseEmail(ctx context.Context, user db.User, provider string) error {
+	fields := map[string]interface{}{db.FieldEmail: nil, db.FieldPassword: nil}
+	if _, err := h.store.Users.UpdateFields(ctx, user.ID, fields, user.Version); err != nil {
+		return err
+	}
+	if err := h.store.Identities.Delete(ctx, db.ProviderEmail, user.Email); err != nil && !errors.Is(err, db.ErrIdentityNotFound) {
+		return err
+	}
+	// Otherwise the owner's inbox could still verify the other account
+	if err := h.store.OTPs.DeleteByIdentifier(ctx, user.Email); err != nil {
+		return err
+	}
+	log.Printf("Unverified email released from account %s to a verified %s sign-in", user.ID, provider)
+	return nil
+}
+
+// markVerified verifies an account signed in to through a provider. Accounts
+// created by provider sign-in before that counted as verification were left
+// without a way to verify.
+func (h *Handler) markVerified(ctx context.Context, user db.User) (db.User, error) {
+	if user.IsVerified {
+		return user, nil
+	}
+	if err := h.store.Users.SetVerified(ctx, user.ID); err != nil {
+		return db.User{}, err
+	}
+	user.IsVerified = true
+	return user, nil
+}
+
+// link attaches ext to userID
+func (h *Handler) link(ctx context.Context, userID string, ext ExternalIdentity) error {
+	return h.store.Identities.Create(ctx, db.Identity{
+		UserID:   userID,
+		Provider: ext.Provider,
+		Subject:  ext.Subject,
+		Email:    ext.Email,
+		Verified: ext.EmailVerified,
+		LinkedAt: time.Now().UTC(),
+	})
+}
+
+// addIdentity records an email or phone login created at signup
+func (h *Handler) addIdentity(ctx context.Context, userID, provider, subject string) {
+	identity := db.Identity{UserID: userID, Provider: provider, Subject: subject, LinkedAt: time.Now().UTC()}
+	if provider == db.ProviderEmail {
+		identity.Email = subject
+	}
+	if err := h.store.Identities.Create(ctx, identity); err != nil {
+		log.Printf("Failed to record %s identity for %s: %v", provider, userID, err)
+	}
+}
+
+// SessionCookie is the cookie the session token is kept in
+const SessionCookie = "token"
+
+// startSession issues a JWT for userID and sets it as the session cookie
+func startSession(w http.ResponseWriter, userID string) (string, error) {
+	token, err := GenerateJWT(userID)
+	if err != nil {
+		return "", err
+	}
+	cookie := sessionCookie(token)
+	cookie.Expires = time.Now().Add(72 * time.Hour)
+	http.SetCookie(w, cookie)
+	return token, nil
+}
+
+// EndSession clears the session cookie
+func EndSession(w http.ResponseWriter) {
+	cookie := sessionCookie("")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// sessionCookie returns the session cookie holding value with the configured
+// attributes, the same for setting and clearing it so both reach one cookie
+func sessionCookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: cookieSameSite,
+	}
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"astromatch/db"
+	"astromatch/db/memory"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newIdentityTest returns a handler whose store holds ada, an email signup that
+// verified its address by OTP
+func newIdentityTest(t *testing.T) *Handler {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStore()
+	if err := store.Users.Create(ctx, db.User{ID: "ada", Email: "ada@example.com", SignupMethod: db.ProviderEmail, IsVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Identities.Create(ctx, db.Identity{UserID: "ada", Provider: db.ProviderEmail, Subject: "ada@example.com", Email: "ada@example.com", Verified: true}); err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(store)
+}
+
+func TestSignInLinksOnlyVouchedEmails(t *testing.T) {
+	h := newIdentityTest(t)
+	ctx := context.Background()
+
+	// An untrusted partner's email claim arrives unverified
+	untrusted := ExternalIdentity{Provider: "acme", Subject: "acme-1", Email: "ada@example.com"}
+	if _, _, err := h.signInWithIdentity(ctx, untrusted); !errors.Is(err, errAccountExists) {
+		t.Fatalf("untrusted sign-in: got %v, want errAccountExists", err)
+	}
+	if _, err := h.store.Identities.Find(ctx, "acme", "acme-1"); !errors.Is(err, db.ErrIdentityNotFound) {
+		t.Errorf("untrusted identity was linked: %v", err)
+	}
+
+	trusted := ExternalIdentity{Provider: db.ProviderGoogle, Subject: "google-1", Email: "ada@example.com", EmailVerified: true}
+	user, created, err := h.signInWithIdentity(ctx, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "ada" || created {
+		t.Errorf("trusted sign-in: got user %q, created %v", user.ID, created)
+	}
+	if identity, err := h.store.Identities.Find(ctx, db.ProviderGoogle, "google-1"); err != nil || identity.UserID != "ada" {
+		t.Errorf("trusted identity not linked to ada: %+v, %v", identity, err)
+	}
+}
+
+func TestProviderSignInVerifiesAccount(t *testing.T) {
+	h := newIdentityTest(t)
+	ctx := context.Background()
+
+	// Facebook never vouches for the email, but its sign-in is the verification
+	user, created, err := h.signInWithIdentity(ctx, ExternalIdentity{Provider: db.ProviderFacebook, Subject: "fb-1", Email: "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || !user.IsVerified {
+		t.Errorf("new provider account: created %v, verified %v", created, user.IsVerified)
+	}
+	// It does not claim the unproven address, which stays free for its owner
+	if user.Email != "" {
+		t.Errorf("account claimed unverified email %q", user.Email)
+	}
+	if _, err := h.store.Users.GetByEmail(ctx, "bob@example.com"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("unverified email is taken: %v", err)
+	}
+	if identity, err := h.store.Identities.Find(ctx, db.ProviderFacebook, "fb-1"); err != nil || identity.Email != "bob@example.com" || identity.Verified {
+		t.Errorf("identity does not keep the unverified email: %+v, %v", identity, err)
+	}
+
+	// Accounts created unverified before are verified at their next sign-in
+	if err := h.store.Users.Create(ctx, db.User{ID: "old", SignupMethod: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.store.Identities.Create(ctx, db.Identity{UserID: "old", Provider: "acme", Subject: "acme-old"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.signInWithIdentity(ctx, ExternalIdentity{Provider: "acme", Subject: "acme-old"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := h.store.Users.GetByID(ctx, "old"); !stored.IsVerified {
+		t.Error("existing provider account is still unverified after signing in")
+	}
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	oldJWT, oldSameSite, oldSecure := jwtKey, cookieSameSite, cookieSecure
+	t.Cleanup(func() { jwtKey, cookieSameSite, cookieSecure = oldJWT, oldSameSite, oldSecure })
+	jwtKey = []byte("test-jwt-secret")
+
+	tests := []struct {
+		cfg      Config
+		sameSite http.SameSite
+		secure   bool
+	}{
+		{Config{}, http.SameSiteLaxMode, false},
+		{Config{CookieSameSite: "strict", CookieSecure: true}, http.SameSiteStrictMode, true},
+		// Browsers reject SameSite=None without Secure
+		{Config{CookieSameSite: "none"}, http.SameSiteNoneMode, true},
+	}
+	for _, tt := range tests {
+		initSessionCookie(tt.cfg)
+
+		w := httptest.NewRecorder()
+		if _, err := startSession(w, "ada"); err != nil {
+			t.Fatal(err)
+		}
+		EndSession(w)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 2 {
+			t.Fatalf("got %d cookies, want 2", len(cookies))
+		}
+		for _, c := range cookies {
+			if c.Name != SessionCookie || c.Path != "/" || !c.HttpOnly || c.SameSite != tt.sameSite || c.Secure != tt.secure {
+				t.Errorf("%+v: got cookie %s", tt.cfg, c)
+			}
+		}
+		if cookies[0].Value == "" || cookies[1].MaxAge >= 0 {
+			t.Errorf("%+v: session not set then cleared: %s, %s", tt.cfg, cookies[0], cookies[1])
+		}
+	}
+}
+
+func TestVerifiedSignInReleasesSquattedEmail(t *testing.T) {
+	h := newIdentityTest(t)
+	ctx := context.Background()
+
+	// Someone signed up with eve's address and never verified it
+	if err := h.store.Users.Create(ctx, db.User{ID: "squatter", Email: "eve@example.com", Password: "hash", SignupMethod: db.ProviderEmail}); err != nil {
+		t.Fatal(err)
+	}
+	h.addIdentity(ctx, "squatter", db.ProviderEmail, "eve@example.com")
+	h.storeOTP(ctx, "eve@example.com", "123456")
+
+	user, created, err := h.signInWithIdentity(ctx, ExternalIdentity{Provider: db.ProviderGoogle, Subject: "google-eve", Email: "eve@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || user.ID == "squatter" || user.Email != "eve@example.com" {
+		t.Errorf("got user %+v, created %v; want a new account with the email", user, created)
+	}
+
+	squatter, err := h.store.Users.GetByID(ctx, "squatter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if squatter.Email != "" || squatter.Password != "" {
+		t.Errorf("squatter kept the email or password: %+v", squatter)
+	}
+	if _, err := h.store.Identities.Find(ctx, db.ProviderEmail, "eve@example.com"); !errors.Is(err, db.ErrIdentityNotFound) {
+		t.Errorf("squatter's email identity survived: %v", err)
+	}
+	if otps, _ := h.store.OTPs.ListValid(ctx, "eve@example.com", time.Now()); len(otps) != 0 {
+		t.Errorf("pending OTPs for the email survived: %d", len(otps))
+	}
+
+	// A verified account keeps its address
+	if _, _, err := h.signInWithIdentity(ctx, ExternalIdentity{Provider: db.ProviderApple, Subject: "apple-1", Email: "ada@example.com", EmailVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ada, _ := h.store.Users.GetByID(ctx, "ada"); ada.Email != "ada@example.com" {
+		t.Errorf("verified account lost its email: %+v", ada)
+	}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"astromatch/auth/jwks"
+	"astromatch/db"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Default key endpoints of the built-in OpenID Connect providers
+const (
+	GoogleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+	AppleKeysURL   = "https://appleid.apple.com/auth/keys"
+)
+
+// tokenClockSkew tolerates small clock differences with token issuers
+const tokenClockSkew = time.Minute
+
+// IDTokenVerifier is an IdentityProvider for OpenID Connect ID tokens, checked
+// locally against the issuer's published signing keys
+type IDTokenVerifier struct {
+	Provider string
+	// Issuers are the accepted iss values
+	Issuers []string
+	// ClientIDs are our OAuth client IDs (web, iOS, Android) tokens may be issued to
+	ClientIDs []string
+	Keys      *jwks.Set
+	// RequireVerifiedEmail refuses tokens carrying an email the provider has not verified
+	RequireVerifiedEmail bool
+	// Now returns the current time; nil uses time.Now
+	Now func() time.Time
+}
+
+// NewGoogleProvider verifies Google ID tokens issued to clientIDs. An empty
+// certsURL uses Google's.
+func NewGoogleProvider(clientIDs []string, certsURL string) *IDTokenVerifier {
+	if certsURL == "" {
+		certsURL = GoogleCertsURL
+	}
+	return &IDTokenVerifier{
+		Provider:             db.ProviderGoogle,
+		Issuers:              []string{"https://accounts.google.com", "accounts.google.com"},
+		ClientIDs:            clientIDs,
+		Keys:                 jwks.New(certsURL, nil),
+		RequireVerifiedEmail: true,
+	}
+}
+
+// NewAppleProvider verifies Sign in with Apple ID tokens issued to clientIDs, the
+// app bundle IDs and web services IDs. An empty keysURL uses Apple's.
+func NewAppleProvider(clientIDs []string, keysURL string) *IDTokenVerifier {
+	if keysURL == "" {
+		keysURL = AppleKeysURL
+	}
+	return &IDTokenVerifier{
+		Provider:  db.ProviderApple,
+		Issuers:   []string{"https://appleid.apple.com"},
+		ClientIDs: clientIDs,
+		Keys:      jwks.New(keysURL, nil),
+		// Apple only hands out addresses it has verified, including relay addresses
+		RequireVerifiedEmail: true,
+	}
+}
+
+// idTokenClaims are the ID token claims we use
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Email         string    `json:"email"`
+	EmailVerified claimBool `json:"email_verified"`
+	Name          string    `json:"name"`
+	Picture       string    `json:"picture"`
+}
+
+// claimBool accepts a boolean claim sent either as JSON true or as "true", which
+// Apple and older Google tokens do
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = claimBool(v)
+	case string:
+		*b = claimBool(v == "true")
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// Name implements IdentityProvider
+func (v *IDTokenVerifier) Name() string { return v.Provider }
+
+// Verify checks the token's signature, issuer, audience and lifetime and returns
+// the account it identifies
+func (v *IDTokenVerifier) Verify(ctx context.Context, token string) (ExternalIdentity, error) {
+	var claims idTokenClaims
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(token, &claims, v.Keys.Keyfunc(ctx)); err != nil {
+		return ExternalIdentity{}, fmt.Errorf("%s: %w", v.Provider, err)
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	var err error
+	switch {
+	case !slices.Contains(v.Issuers, claims.Issuer):
+		err = fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(v.ClientIDs, aud) }):
+		err = fmt.Errorf("token issued to another client %v", claims.Audience)
+	case claims.ExpiresAt == nil:
+		err = errors.New("token has no expiry")
+	case !now.Before(claims.ExpiresAt.Add(tokenClockSkew)):
+		err = errors.New("token expired")
+	case claims.IssuedAt != nil && claims.IssuedAt.After(now.Add(tokenClockSkew)):
+		err = errors.New("token issued in the future")
+	case claims.Subject == "":
+		err = errors.New("token has no subject")
+	case v.RequireVerifiedEmail && claims.Email != "" && !bool(claims.EmailVerified):
+		err = errors.New("email is not verified")
+	}
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("%s: %w", v.Provider, err)
+	}
+
+	return ExternalIdentity{
+		Provider:      v.Provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"astromatch/auth/jwks"
+	"astromatch/auth/jwks/jwkstest"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testClientID = "client-web"
+)
+
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestVerifier(t *testing.T) (*IDTokenVerifier, *jwkstest.Server) {
+	t.Helper()
+	server, err := jwkstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return &IDTokenVerifier{
+		Provider:             "test",
+		Issuers:              []string{testIssuer},
+		ClientIDs:            []string{"client-ios", testClientID},
+		Keys:                 jwks.New(server.URL, server.Client()),
+		RequireVerifiedEmail: true,
+		Now:                  func() time.Time { return testNow },
+	}, server
+}
+
+// validClaims are claims Verify accepts; tests break one thing at a time
+func validClaims() idTokenClaims {
+	return idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(testNow.Add(-time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(testNow.Add(time.Hour)),
+		},
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Name:          "Ada",
+	}
+}
+
+func sign(t *testing.T, server *jwkstest.Server, claims idTokenClaims) string {
+	t.Helper()
+	token, err := server.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestIDTokenVerify(t *testing.T) {
+	v, server := newTestVerifier(t)
+
+	identity, err := v.Verify(context.Background(), sign(t, server, validClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ExternalIdentity{Provider: "test", Subject: "subject-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	if identity != want {
+		t.Errorf("got %+v, want %+v", identity, want)
+	}
+}
+
+func TestIDTokenRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*idTokenClaims)
+		want   string
+	}{
+		{"wrong issuer", func(c *idTokenClaims) { c.Issuer = "https://evil.example.com" }, "unexpected issuer"},
+		{"wrong audience", func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"someone-else"} }, "another client"},
+		{"expired", func(c *idTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(testNow.Add(-tokenClockSkew)) }, "expired"},
+		{"no expiry", func(c *idTokenClaims) { c.ExpiresAt = nil }, "no expiry"},
+		{"issued in the future", func(c *idTokenClaims) { c.IssuedAt = jwt.NewNumericDate(testNow.Add(2 * tokenClockSkew)) }, "future"},
+		{"no subject", func(c *idTokenClaims) { c.Subject = "" }, "no subject"},
+		{"unverified email", func(c *idTokenClaims) { c.EmailVerified = false }, "not verified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, server := newTestVerifier(t)
+			claims := validClaims()
+			tt.modify(&claims)
+			_, err := v.Verify(context.Background(), sign(t, server, claims))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIDTokenWithinClockSkew(t *testing.T) {
+	v, server := newTestVerifier(t)
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(testNow.Add(-tokenClockSkew + time.Second))
+	if _, err := v.Verify(context.Background(), sign(t, server, claims)); err != nil {
+		t.Errorf("token expired within the allowed skew: %v", err)
+	}
+}
+
+func TestIDTokenBadSignature(t *testing.T) {
+	v, _ := newTestVerifier(t)
+	// Another server's first key has the same key ID but a different key
+	impostor, err := jwkstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer impostor.Close()
+
+	if _, err := v.Verify(context.Background(), sign(t, impostor, validClaims())); err == nil {
+		t.Fatal("accepted a token signed with another key")
+	}
+
+	token := sign(t, impostor, validClaims())
+	parts := strings.Split(token, ".")
+	if _, err := v.Verify(context.Background(), parts[0]+"."+parts[1]+"."); err == nil {
+		t.Error("accepted a token without a signature")
+	}
+}
+
+func TestIDTokenReusesKeys(t *testing.T) {
+	v, server := newTestVerifier(t)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), sign(t, server, validClaims())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := server.Requests(); got != 1 {
+		t.Errorf("keys fetched %d times for three tokens, want 1", got)
+	}
+}
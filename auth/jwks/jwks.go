@@ -0,0 +1,204 @@
+// Package jwks fetches and caches the JSON Web Key Sets identity providers sign
+// their ID tokens with
+package jwks
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// defaultMaxAge applies when the key endpoint sends no Cache-Control max-age
+	defaultMaxAge = time.Hour
+	// minRefreshInterval limits refetches triggered by unknown key IDs, so forged
+	// tokens cannot make us hammer the provider
+	minRefreshInterval = time.Minute
+)
+
+// ErrUnknownKey means no published key has the token's key ID
+var ErrUnknownKey = errors.New("jwks: unknown key ID")
+
+// Set is a cached key set. Keys are refetched when the provider's cache lifetime
+// runs out, or early when a token names a key we have not seen, which is how
+// providers roll out new keys. While the endpoint is down the last keys it served
+// stay in use.
+type Set struct {
+	url    string
+	client *http.Client
+	// now is the clock cache lifetimes are measured with
+	now func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	expires   time.Time
+	fetchedAt time.Time
+	// fetchErr is why the last fetch failed, nil after one succeeds
+	fetchErr error
+	// fetching is closed when the fetch in progress, if any, completes
+	fetching chan struct{}
+}
+
+// New returns a Set for the key endpoint at url. A nil client uses one with a
+// ten second timeout.
+func New(url string, client *http.Client) *Set {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Set{url: url, client: client, now: time.Now}
+}
+
+// Key returns the public key with ID kid. One caller at a time fetches, without
+// holding the lock; the others use the keys already cached, waiting for the
+// fetch only when they have none for kid.
+func (s *Set) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	now := s.now()
+	key, ok := s.keys[kid]
+	if ok && now.Before(s.expires) {
+		s.mu.Unlock()
+		return key, nil
+	}
+
+	// Failed fetches count against the interval too, so an outage costs one
+	// request a minute rather than one per login
+	switch done := s.fetching; {
+	case done == nil && now.Sub(s.fetchedAt) >= minRefreshInterval:
+		previous := s.fetchedAt
+		s.fetchedAt = now
+		done = make(chan struct{})
+		s.fetching = done
+		s.mu.Unlock()
+
+		keys, expires, err := s.fetch(ctx, now)
+
+		s.mu.Lock()
+		s.fetchErr = err
+		if err == nil {
+			s.keys, s.expires = keys, expires
+		} else if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the endpoint
+			s.fetchedAt = previous
+		}
+		s.fetching = nil
+		close(done)
+	case done != nil && !ok:
+		s.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	defer s.mu.Unlock()
+
+	// A known key outlives a failed refresh rather than failing every login
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if s.fetchErr != nil {
+		return nil, s.fetchErr
+	}
+	return nil, ErrUnknownKey
+}
+
+// Keyfunc adapts the set for jwt.Parse, looking keys up by the token's kid header
+func (s *Set) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("jwks: token has no key ID")
+		}
+		return s.Key(ctx, kid)
+	}
+}
+
+// jsonWebKey is one entry of a key set; only RSA signing keys are used
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// fetch returns the keys currently published and when they expire
+func (s *Set) fetch(ctx context.Context, now time.Time) (map[string]*rsa.PublicKey, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("jwks: fetching %s: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("jwks: fetching %s: status %d", s.url, resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, time.Time{}, fmt.Errorf("jwks: decoding %s: %w", s.url, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := rsaKey(jwk)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("jwks: key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, time.Time{}, fmt.Errorf("jwks: %s has no RSA signing keys", s.url)
+	}
+	return keys, now.Add(maxAge(resp.Header.Get("Cache-Control"))), nil
+}
+
+func rsaKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("unsupported exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// maxAge reads max-age from a Cache-Control header
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultMaxAge
+}
@@ -0,0 +1,186 @@
+package jwks
+
+import (
+	"astromatch/auth/jwks/jwkstest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newTestSet returns a Set on a fresh key server with a clock the test moves by hand
+func newTestSet(t *testing.T) (*Set, *jwkstest.Server, *time.Time) {
+	t.Helper()
+	server, err := jwkstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	clock := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	set := New(server.URL, server.Client())
+	set.now = func() time.Time { return clock }
+	return set, server, &clock
+}
+
+// kid returns the key ID a fresh token from server is signed with
+func kid(t *testing.T, server *jwkstest.Server) string {
+	t.Helper()
+	token, err := server.Sign(jwt.RegisteredClaims{Subject: "someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Header["kid"].(string)
+}
+
+func TestKeysAreCached(t *testing.T) {
+	set, server, clock := newTestSet(t)
+	server.MaxAge = 600
+	current := kid(t, server)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := set.Key(ctx, current); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := server.Requests(); got != 1 {
+		t.Fatalf("fetched %d times for repeated lookups, want 1", got)
+	}
+
+	*clock = clock.Add(10*time.Minute - time.Second)
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Requests(); got != 1 {
+		t.Errorf("fetched again within max-age: %d requests", got)
+	}
+
+	*clock = clock.Add(time.Second)
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Requests(); got != 2 {
+		t.Errorf("not refetched once max-age ran out: %d requests", got)
+	}
+}
+
+func TestUnknownKeyRefetchesAfterRotation(t *testing.T) {
+	set, server, clock := newTestSet(t)
+	ctx := context.Background()
+	if _, err := set.Key(ctx, kid(t, server)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := kid(t, server)
+
+	// A refetch just happened, so an unknown key waits out the refresh interval
+	if _, err := set.Key(ctx, rotated); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("got %v, want ErrUnknownKey", err)
+	}
+	if got := server.Requests(); got != 1 {
+		t.Fatalf("unknown key refetched within the refresh interval: %d requests", got)
+	}
+
+	*clock = clock.Add(minRefreshInterval)
+	if _, err := set.Key(ctx, rotated); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if got := server.Requests(); got != 2 {
+		t.Errorf("got %d requests, want one refetch for the rotated key", got)
+	}
+
+	*clock = clock.Add(minRefreshInterval)
+	if _, err := set.Key(ctx, "forged"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+	if _, err := set.Key(ctx, "forged"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want ErrUnknownKey", err)
+	}
+	if got := server.Requests(); got != 3 {
+		t.Errorf("repeated unknown keys fetched %d times, want one more refetch", got-2)
+	}
+}
+
+func TestKnownKeyOutlivesFailedRefresh(t *testing.T) {
+	set, server, clock := newTestSet(t)
+	ctx := context.Background()
+	current := kid(t, server)
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	*clock = clock.Add(2 * defaultMaxAge)
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Errorf("known key lost when the refresh failed: %v", err)
+	}
+}
+
+func TestExpiredKeysServedDuringOutage(t *testing.T) {
+	set, server, clock := newTestSet(t)
+	ctx := context.Background()
+	current := kid(t, server)
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	server.Fail(release)
+	*clock = clock.Add(2 * defaultMaxAge)
+
+	// One caller refetches; the others are answered from the cache meanwhile
+	const callers = 8
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			_, err := set.Key(ctx, current)
+			errs <- err
+		}()
+	}
+	for i := 0; i < callers-1; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("caller during the refetch: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("callers wait for the refetch while a cached key exists")
+		}
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Errorf("refetching caller lost the cached key: %v", err)
+	}
+	if got := server.Requests(); got != 2 {
+		t.Fatalf("got %d requests, want one refetch", got)
+	}
+
+	// The failed refetch counts against the refresh interval
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.Key(ctx, "rotated"); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key during the outage: got %v, want the fetch error", err)
+	}
+	if got := server.Requests(); got != 2 {
+		t.Errorf("refetched within the refresh interval after a failure: %d requests", got)
+	}
+
+	*clock = clock.Add(minRefreshInterval)
+	if _, err := set.Key(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.Requests(); got != 3 {
+		t.Errorf("got %d requests, want a retry once the interval passed", got)
+	}
+}
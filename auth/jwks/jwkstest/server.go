@@ -0,0 +1,118 @@
+// Package jwkstest stands in for an identity provider's key endpoint: it serves a
+// key set over HTTP and signs tokens with it, so token verification can be
+// exercised offline
+package jwkstest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Server is a local key endpoint. Close it when done.
+type Server struct {
+	*httptest.Server
+
+	// MaxAge is sent as the Cache-Control max-age in seconds; zero sends none
+	MaxAge int
+
+	mu       sync.Mutex
+	keys     []signingKey
+	failing  bool
+	release  <-chan struct{}
+	requests atomic.Int64
+}
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// NewServer starts a key endpoint publishing one freshly generated key
+func NewServer() (*Server, error) {
+	s := &Server{}
+	if err := s.Rotate(); err != nil {
+		return nil, err
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveKeys))
+	return s, nil
+}
+
+// Rotate generates a new signing key. Earlier keys stay published, as providers
+// keep them until tokens signed with them have expired.
+func (s *Server) Rotate() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, signingKey{id: fmt.Sprintf("test-key-%d", len(s.keys)+1), key: key})
+	return nil
+}
+
+// Sign returns claims as an RS256 token signed with the newest key
+func (s *Server) Sign(claims jwt.Claims) (string, error) {
+	s.mu.Lock()
+	current := s.keys[len(s.keys)-1]
+	s.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = current.id
+	return token.SignedString(current.key)
+}
+
+// Fail makes the endpoint answer 503 Service Unavailable, as during an outage.
+// Requests wait for release to close first, unless it is nil.
+func (s *Server) Fail(release <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing, s.release = true, release
+}
+
+// Requests reports how many times the key set has been fetched
+func (s *Server) Requests() int {
+	return int(s.requests.Load())
+}
+
+func (s *Server) serveKeys(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+
+	s.mu.Lock()
+	if s.failing {
+		release := s.release
+		s.mu.Unlock()
+		if release != nil {
+			<-release
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	keys := make([]map[string]string, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": k.id,
+			"n":   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if s.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.MaxAge))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -2,38 +2,59 @@ package auth
 
 import (
 	"astromatch/db"
+	"astromatch/respond"
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"time"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var creds db.User
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request")
 		return
 	}
 
-	user, err := db.GetUserByEmail(creds.Email)
+	user, err := h.store.Users.GetByEmail(r.Context(), creds.Email)
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		respond.DBError(w, r, err, "Login failed")
+		return
+	}
 	if err != nil || !CheckPasswordHash(creds.Password, user.Password) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidCredentials, "Invalid credentials")
 		return
 	}
 
-	token, err := GenerateJWT(user.ID)
-	if err != nil {
-		http.Error(w, "Token generation failed", http.StatusInternalServerError)
+	if h.requireMFA(w, r, user) {
+		return
+	}
+
+	if err := h.restoreAccount(r.Context(), user); err != nil {
+		respond.DBError(w, r, err, "Login failed")
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  time.Now().Add(72 * time.Hour),
-		HttpOnly: true,
-	})
+	if _, err := startSession(w, user.ID); err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Token generation failed")
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
 }
+
+// restoreAccount cancels a pending account deletion; logging in during the grace
+// period is how users change their mind
+func (h *Handler) restoreAccount(ctx context.Context, user db.User) error {
+	if user.DeletedAt == nil {
+		return nil
+	}
+	if _, err := h.store.Users.UpdateFields(ctx, user.ID, map[string]interface{}{db.FieldDeletedAt: nil}, db.AnyVersion); err != nil {
+		return err
+	}
+	log.Printf("Account deletion cancelled by login: %s", user.ID)
+	return nil
+}
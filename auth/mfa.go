@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"astromatch/db"
+	"astromatch/respond"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mfaChallengeTTL is how long a login that passed the first factor may take to
+// present the second
+const mfaChallengeTTL = 5 * time.Minute
+
+// purposeMFA marks MFA challenge tokens, which cannot be used as sessions
+const purposeMFA = "mfa"
+
+// errInvalidMFACode means the authenticator or recovery code was wrong or already used
+var errInvalidMFACode = errors.New("invalid two-factor code")
+
+// MFACodeRequest carries a second factor: an authenticator code or a recovery code.
+// MFAToken is the challenge from the first login step, when completing a login.
+type MFACodeRequest struct {
+	MFAToken     string `json:"mfaToken,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+// mfaChallengeResponse replaces the session when an account has 2FA enabled
+type mfaChallengeResponse struct {
+	Message     string `json:"message"`
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
+type mfaStatusResponse struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabledAt,omitempty"`
+	RecoveryCodesRemaining int        `json:"recoveryCodesRemaining"`
+}
+
+// enrollmentResponse is shown once; URI is meant to be rendered as a QR code
+type enrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFA reports (GET) or disables (DELETE, with a current code) two-factor authentication
+func (h *Handler) MFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status := mfaStatusResponse{}
+		if user.MFA != nil && user.MFA.Enabled {
+			status = mfaStatusResponse{Enabled: true, EnabledAt: user.MFA.EnabledAt, RecoveryCodesRemaining: len(user.MFA.RecoveryCodes)}
+		}
+		respond.JSON(w, http.StatusOK, status)
+	case http.MethodDelete:
+		var req MFACodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+			return
+		}
+		if user.MFA == nil || !user.MFA.Enabled {
+			respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "Two-factor authentication is not enabled")
+			return
+		}
+		if _, err := h.verifySecondFactor(r.Context(), user, req); err != nil {
+			writeMFAError(w, r, err)
+			return
+		}
+		if _, err := h.store.Users.UpdateFields(r.Context(), user.ID, map[string]interface{}{db.FieldMFA: nil}, db.AnyVersion); err != nil {
+			respond.DBError(w, r, err, "Failed to disable two-factor authentication")
+			return
+		}
+		log.Printf("Two-factor authentication disabled for %s", user.ID)
+		respond.JSON(w, http.StatusOK, mfaStatusResponse{})
+	default:
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// EnrollTOTP (POST) starts enrollment with a new secret. It only takes effect
+// once ConfirmTOTP sees a code generated from it.
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.MFA != nil && user.MFA.Enabled {
+		respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to start enrollment")
+		return
+	}
+	sealed, err := sealSecret(secret, user.ID)
+	if err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+	mfa := db.MFA{PendingSecret: sealed}
+	if _, err := h.store.Users.UpdateFields(r.Context(), user.ID, map[string]interface{}{db.FieldMFA: mfa}, user.Version); err != nil {
+		respond.DBError(w, r, err, "Failed to start enrollment")
+		return
+	}
+
+	account := user.Email
+	if account == "" {
+		account = user.Phone
+	}
+	respond.JSON(w, http.StatusCreated, enrollmentResponse{
+		Secret: base32NoPad.EncodeToString(secret),
+		URI:    provisioningURI(account, secret),
+	})
+}
+
+// ConfirmTOTP (POST) enables two-factor authentication when the code matches the
+// pending secret, and returns the first batch of recovery codes
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if user.MFA == nil || user.MFA.PendingSecret == "" {
+		respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "No two-factor enrollment in progress")
+		return
+	}
+
+	secret, err := openSecret(user.MFA.PendingSecret, user.ID)
+	if err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+	now := h.now()
+	step, ok := matchTOTP(secret, req.Code, now, 0)
+	if !ok {
+		writeMFAError(w, r, errInvalidMFACode)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+
+	enabledAt := now.UTC()
+	mfa := db.MFA{
+		Secret:        user.MFA.PendingSecret,
+		Enabled:       true,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+		EnabledAt:     &enabledAt,
+	}
+	if _, err := h.store.Users.UpdateFields(r.Context(), user.ID, map[string]interface{}{db.FieldMFA: mfa}, user.Version); err != nil {
+		respond.DBError(w, r, err, "Failed to enable two-factor authentication")
+		return
+	}
+	log.Printf("Two-factor authentication enabled for %s", user.ID)
+	respond.JSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RecoveryCodes (POST, with a current code) replaces all recovery codes with a new batch
+func (h *Handler) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if user.MFA == nil || !user.MFA.Enabled {
+		respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	user, err := h.verifySecondFactor(r.Context(), user, req)
+	if err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+	mfa := *user.MFA
+	mfa.RecoveryCodes = hashes
+	if _, err := h.store.Users.UpdateFields(r.Context(), user.ID, map[string]interface{}{db.FieldMFA: mfa}, user.Version); err != nil {
+		respond.DBError(w, r, err, "Failed to regenerate recovery codes")
+		return
+	}
+	respond.JSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyMFA completes a login by checking the second factor against the challenge
+// issued by the first step, then starts the session
+func (h *Handler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	claims, err := parseMFAChallenge(req.MFAToken)
+	if err != nil {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidToken, "Login expired, please log in again")
+		return
+	}
+	user, err := h.store.Users.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		respond.DBError(w, r, err, "Login failed")
+		return
+	}
+	if user.MFA == nil || !user.MFA.Enabled {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidToken, "Login expired, please log in again")
+		return
+	}
+	if user, err = h.verifySecondFactor(r.Context(), user, req); err != nil {
+		writeMFAError(w, r, err)
+		return
+	}
+
+	if err := h.restoreAccount(r.Context(), user); err != nil {
+		respond.DBError(w, r, err, "Login failed")
+		return
+	}
+	token, err := startSession(w, user.ID)
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Token generation failed")
+		return
+	}
+	respond.JSON(w, http.StatusOK, map[string]string{"message": "Login successful", "token": token})
+}
+
+// requireMFA answers with an MFA challenge instead of a session when user has
+// two-factor authentication enabled, and reports whether it did
+func (h *Handler) requireMFA(w http.ResponseWriter, r *http.Request, user db.User) bool {
+	if user.MFA == nil || !user.MFA.Enabled {
+		return false
+	}
+	token, err := issueMFAChallenge(user.ID)
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Token generation failed")
+		return true
+	}
+	respond.JSON(w, http.StatusOK, mfaChallengeResponse{
+		Message:     "Two-factor authentication required",
+		MFARequired: true,
+		MFAToken:    token,
+	})
+	return true
+}
+
+// verifySecondFactor checks req against user's authenticator or recovery codes
+// and records the use, so neither can be replayed. A concurrent update makes it
+// start over from the stored state.
+func (h *Handler) verifySecondFactor(ctx context.Context, user db.User, req MFACodeRequest) (db.User, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		if user.MFA == nil || !user.MFA.Enabled {
+			return db.User{}, errInvalidMFACode
+		}
+		mfa := *user.MFA
+
+		switch {
+		case req.Code != "":
+			secret, err := openSecret(mfa.Secret, user.ID)
+			if err != nil {
+				return db.User{}, err
+			}
+			step, ok := matchTOTP(secret, req.Code, h.now(), mfa.LastStep)
+			if !ok {
+				return db.User{}, errInvalidMFACode
+			}
+			mfa.LastStep = step
+		case req.RecoveryCode != "":
+			i := matchRecoveryCode(mfa.RecoveryCodes, req.RecoveryCode)
+			if i < 0 {
+				return db.User{}, errInvalidMFACode
+			}
+			mfa.RecoveryCodes = append(append([]string{}, mfa.RecoveryCodes[:i]...), mfa.RecoveryCodes[i+1:]...)
+			log.Printf("Recovery code used by %s, %d left", user.ID, len(mfa.RecoveryCodes))
+		default:
+			return db.User{}, errInvalidMFACode
+		}
+
+		updated, err := h.store.Users.UpdateFields(ctx, user.ID, map[string]interface{}{db.FieldMFA: mfa}, user.Version)
+		if !errors.Is(err, db.ErrVersionMismatch) {
+			return updated, err
+		}
+		if user, err = h.store.Users.GetByID(ctx, user.ID); err != nil {
+			return db.User{}, err
+		}
+	}
+	return db.User{}, db.ErrVersionMismatch
+}
+
+// currentUser loads the authenticated user, answering the request when it cannot
+func (h *Handler) currentUser(w http.ResponseWriter, r *http.Request) (db.User, bool) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return db.User{}, false
+	}
+	user, err := h.store.Users.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to load account")
+		return db.User{}, false
+	}
+	return user, true
+}
+
+func writeMFAError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidMFACode):
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidMFACode, "Invalid or already used two-factor code")
+	case errors.Is(err, errMFAUnavailable):
+		respond.Error(w, r, http.StatusServiceUnavailable, respond.CodeUnavailable, "Two-factor authentication is not available")
+	default:
+		respond.DBError(w, r, err, "Two-factor authentication failed")
+	}
+}
+
+// issueMFAChallenge returns a short-lived token proving userID passed the first factor
+func issueMFAChallenge(userID string) (string, error) {
+	claims := &Claims{
+		UserID:  userID,
+		Purpose: purposeMFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaChallengeTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+}
+
+// MFAChallengeUserID returns the user a valid MFA challenge was issued to, or ""
+func MFAChallengeUserID(tokenString string) string {
+	claims, err := parseMFAChallenge(tokenString)
+	if err != nil {
+		return ""
+	}
+	return claims.UserID
+}
+
+// parseMFAChallenge validates a token from issueMFAChallenge
+func parseMFAChallenge(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeMFA {
+		return nil, errors.New("not an MFA challenge")
+	}
+	return claims, nil
+}
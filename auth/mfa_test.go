@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"astromatch/db"
+	"astromatch/db/memory"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mfaTest is a handler on a memory store with a clock the test moves by hand
+type mfaTest struct {
+	t     *testing.T
+	h     *Handler
+	clock time.Time
+	user  db.User
+}
+
+func newMFATest(t *testing.T) *mfaTest {
+	t.Helper()
+	oldJWT, oldMFA := jwtKey, mfaKey
+	jwtKey = []byte("test-jwt-secret")
+	mfaKey = bytes.Repeat([]byte{7}, 32)
+	t.Cleanup(func() { jwtKey, mfaKey = oldJWT, oldMFA })
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := db.User{ID: "user-1", Email: "ada@example.com", Password: hash, SignupMethod: db.ProviderEmail, IsVerified: true}
+	store := memory.NewStore()
+	if err := store.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	mt := &mfaTest{t: t, user: user, clock: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	mt.h = NewHandler(store)
+	mt.h.now = func() time.Time { return mt.clock }
+	return mt
+}
+
+// do calls handler as the test user and decodes the JSON response into out
+func (mt *mfaTest) do(handler http.HandlerFunc, method string, body interface{}, out interface{}) int {
+	mt.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			mt.t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, "/", &buf)
+	r = r.WithContext(WithClaims(r.Context(), &Claims{UserID: mt.user.ID}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if out != nil && w.Code < http.StatusMultipleChoices {
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			mt.t.Fatalf("decoding response: %v", err)
+		}
+	}
+	return w.Code
+}
+
+// code is the authenticator code for the clock's current time step
+func (mt *mfaTest) code(secret []byte) string {
+	return totpCode(secret, totpStep(mt.clock))
+}
+
+// enable enrolls and confirms TOTP, returning the secret and recovery codes
+func (mt *mfaTest) enable() ([]byte, []string) {
+	mt.t.Helper()
+	var enrollment enrollmentResponse
+	if status := mt.do(mt.h.EnrollTOTP, http.MethodPost, nil, &enrollment); status != http.StatusCreated {
+		mt.t.Fatalf("enroll: status %d", status)
+	}
+	secret, err := base32NoPad.DecodeString(enrollment.Secret)
+	if err != nil {
+		mt.t.Fatalf("enrollment secret: %v", err)
+	}
+	var recovery recoveryCodesResponse
+	if status := mt.do(mt.h.ConfirmTOTP, http.MethodPost, MFACodeRequest{Code: mt.code(secret)}, &recovery); status != http.StatusOK {
+		mt.t.Fatalf("confirm: status %d", status)
+	}
+	return secret, recovery.RecoveryCodes
+}
+
+func (mt *mfaTest) stored() db.User {
+	mt.t.Helper()
+	user, err := mt.h.store.Users.GetByID(context.Background(), mt.user.ID)
+	if err != nil {
+		mt.t.Fatal(err)
+	}
+	return user
+}
+
+func TestEnrollAndConfirm(t *testing.T) {
+	mt := newMFATest(t)
+
+	var enrollment enrollmentResponse
+	if status := mt.do(mt.h.EnrollTOTP, http.MethodPost, nil, &enrollment); status != http.StatusCreated {
+		t.Fatalf("enroll: status %d", status)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("URI %q does not carry the secret", enrollment.URI)
+	}
+	secret, err := base32NoPad.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mfa := mt.stored().MFA; mfa == nil || mfa.Enabled || mfa.PendingSecret == "" {
+		t.Fatalf("enrollment should stay pending until confirmed, got %+v", mfa)
+	}
+
+	wrong := totpCode(secret, totpStep(mt.clock)+5)
+	if status := mt.do(mt.h.ConfirmTOTP, http.MethodPost, MFACodeRequest{Code: wrong}, nil); status != http.StatusUnauthorized {
+		t.Errorf("confirm with a wrong code: status %d, want 401", status)
+	}
+
+	var recovery recoveryCodesResponse
+	if status := mt.do(mt.h.ConfirmTOTP, http.MethodPost, MFACodeRequest{Code: mt.code(secret)}, &recovery); status != http.StatusOK {
+		t.Fatalf("confirm: status %d", status)
+	}
+	if len(recovery.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("got %d recovery codes, want %d", len(recovery.RecoveryCodes), recoveryCodeCount)
+	}
+	mfa := mt.stored().MFA
+	if mfa == nil || !mfa.Enabled || mfa.PendingSecret != "" || mfa.EnabledAt == nil || !mfa.EnabledAt.Equal(mt.clock) {
+		t.Fatalf("not enabled at the fake clock's time: %+v", mfa)
+	}
+	if mfa.LastStep != totpStep(mt.clock) {
+		t.Errorf("LastStep = %d, want the confirming step %d", mfa.LastStep, totpStep(mt.clock))
+	}
+
+	if status := mt.do(mt.h.EnrollTOTP, http.MethodPost, nil, nil); status != http.StatusConflict {
+		t.Errorf("enroll while enabled: status %d, want 409", status)
+	}
+}
+
+func TestLoginChallenge(t *testing.T) {
+	mt := newMFATest(t)
+	secret, _ := mt.enable()
+
+	body := strings.NewReader(`{"email":"ada@example.com","password":"correct horse"}`)
+	w := httptest.NewRecorder()
+	mt.h.Login(w, httptest.NewRequest(http.MethodPost, "/login", body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d", w.Code)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Fatalf("password alone started a session: %v", cookies)
+	}
+	var challenge mfaChallengeResponse
+	if err := json.NewDecoder(w.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("no challenge in %+v", challenge)
+	}
+	if _, err := ValidateJWT(challenge.MFAToken); err == nil {
+		t.Error("the challenge token is accepted as a session")
+	}
+
+	if status := mt.do(mt.h.VerifyMFA, http.MethodPost, MFACodeRequest{MFAToken: "garbage", Code: mt.code(secret)}, nil); status != http.StatusUnauthorized {
+		t.Errorf("verify with a bad challenge: status %d, want 401", status)
+	}
+
+	// The confirming code's step is used up; the next one completes the login
+	mt.clock = mt.clock.Add(totpPeriod)
+	req := MFACodeRequest{MFAToken: challenge.MFAToken, Code: mt.code(secret)}
+	var session map[string]string
+	if status := mt.do(mt.h.VerifyMFA, http.MethodPost, req, &session); status != http.StatusOK {
+		t.Fatalf("verify: status %d", status)
+	}
+	claims, err := ValidateJWT(session["token"])
+	if err != nil || claims.UserID != mt.user.ID {
+		t.Fatalf("session token for %v: %v", claims, err)
+	}
+
+	if status := mt.do(mt.h.VerifyMFA, http.MethodPost, req, nil); status != http.StatusUnauthorized {
+		t.Errorf("replayed code: status %d, want 401", status)
+	}
+}
+
+func TestTOTPWindow(t *testing.T) {
+	mt := newMFATest(t)
+	secret, _ := mt.enable()
+	enabled := mt.clock
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		step   int64
+		want   int
+	}{
+		{"two steps ahead", 0, 2, http.StatusUnauthorized},
+		{"one step ahead", 0, 1, http.StatusOK},
+		{"same step again", 0, 1, http.StatusUnauthorized},
+		{"one step behind", 3 * totpPeriod, -1, http.StatusOK},
+		{"two steps behind", 5 * totpPeriod, -2, http.StatusUnauthorized},
+		{"current step", 5 * totpPeriod, 0, http.StatusOK},
+	}
+	for _, tt := range tests {
+		mt.clock = enabled.Add(tt.offset)
+		code := totpCode(secret, totpStep(mt.clock)+tt.step)
+		// Regenerating recovery codes is the simplest endpoint that consumes a code
+		if status := mt.do(mt.h.RecoveryCodes, http.MethodPost, MFACodeRequest{Code: code}, nil); status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	mt := newMFATest(t)
+	_, codes := mt.enable()
+
+	req := MFACodeRequest{RecoveryCode: strings.ToUpper(codes[3])}
+	if status := mt.do(mt.h.RecoveryCodes, http.MethodPost, MFACodeRequest{RecoveryCode: "aaaa-aaaa"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("unknown recovery code: status %d, want 401", status)
+	}
+
+	var status mfaStatusResponse
+	mt.do(mt.h.MFA, http.MethodGet, nil, &status)
+	if status.RecoveryCodesRemaining != recoveryCodeCount {
+		t.Fatalf("%d codes left before use", status.RecoveryCodesRemaining)
+	}
+
+	if code := mt.do(mt.h.MFA, http.MethodDelete, MFACodeRequest{RecoveryCode: "nope"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("disable with a bad recovery code: status %d, want 401", code)
+	}
+	login := func() int {
+		token, err := issueMFAChallenge(mt.user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.MFAToken = token
+		return mt.do(mt.h.VerifyMFA, http.MethodPost, req, nil)
+	}
+	if code := login(); code != http.StatusOK {
+		t.Fatalf("login with a recovery code: status %d", code)
+	}
+	if code := login(); code != http.StatusUnauthorized {
+		t.Errorf("reused recovery code: status %d, want 401", code)
+	}
+	mt.do(mt.h.MFA, http.MethodGet, nil, &status)
+	if status.RecoveryCodesRemaining != recoveryCodeCount-1 {
+		t.Errorf("%d codes left after one use, want %d", status.RecoveryCodesRemaining, recoveryCodeCount-1)
+	}
+}
+
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	mt := newMFATest(t)
+	secret, old := mt.enable()
+
+	if status := mt.do(mt.h.RecoveryCodes, http.MethodPost, MFACodeRequest{}, nil); status != http.StatusUnauthorized {
+		t.Errorf("regenerate without a code: status %d, want 401", status)
+	}
+
+	mt.clock = mt.clock.Add(totpPeriod)
+	var fresh recoveryCodesResponse
+	if status := mt.do(mt.h.RecoveryCodes, http.MethodPost, MFACodeRequest{Code: mt.code(secret)}, &fresh); status != http.StatusOK {
+		t.Fatalf("regenerate: status %d", status)
+	}
+	if len(fresh.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(fresh.RecoveryCodes), recoveryCodeCount)
+	}
+	for _, code := range fresh.RecoveryCodes {
+		for _, previous := range old {
+			if code == previous {
+				t.Fatalf("code %s survived regeneration", code)
+			}
+		}
+	}
+
+	if status := mt.do(mt.h.RecoveryCodes, http.MethodPost, MFACodeRequest{RecoveryCode: old[0]}, nil); status != http.StatusUnauthorized {
+		t.Errorf("old recovery code: status %d, want 401", status)
+	}
+	if status := mt.do(mt.h.RecoveryCodes, http.MethodPost, MFACodeRequest{RecoveryCode: fresh.RecoveryCodes[0]}, nil); status != http.StatusOK {
+		t.Errorf("new recovery code: status %d, want 200", status)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	mt := newMFATest(t)
+	secret, _ := mt.enable()
+
+	// The confirming code cannot be replayed to turn protection off
+	if status := mt.do(mt.h.MFA, http.MethodDelete, MFACodeRequest{Code: mt.code(secret)}, nil); status != http.StatusUnauthorized {
+		t.Errorf("disable with the used code: status %d, want 401", status)
+	}
+
+	mt.clock = mt.clock.Add(totpPeriod)
+	if status := mt.do(mt.h.MFA, http.MethodDelete, MFACodeRequest{Code: mt.code(secret)}, nil); status != http.StatusOK {
+		t.Fatalf("disable: status %d", status)
+	}
+	if mfa := mt.stored().MFA; mfa != nil {
+		t.Errorf("MFA still stored: %+v", mfa)
+	}
+	if status := mt.do(mt.h.MFA, http.MethodDelete, MFACodeRequest{Code: mt.code(secret)}, nil); status != http.StatusConflict {
+		t.Errorf("disable twice: status %d, want 409", status)
+	}
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"email":"ada@example.com","password":"correct horse"}`)
+	mt.h.Login(w, httptest.NewRequest(http.MethodPost, "/login", body))
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) == 0 {
+		t.Errorf("login after disabling: status %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"astromatch/auth/jwks"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig describes a partner's OpenID Connect identity provider, found through
+// its discovery document
+type OIDCConfig struct {
+	// Name is the signupMethod clients use, e.g. "acme"
+	Name string `yaml:"name" json:"name"`
+	// Issuer is the provider's issuer URL; its discovery document lives under
+	// /.well-known/openid-configuration
+	Issuer string `yaml:"issuer" json:"issuer"`
+	// ClientIDs are the client IDs the partner registered for us
+	ClientIDs []string `yaml:"clientIds" json:"clientIds"`
+	// TrustEmail accepts the provider's email_verified claim. Only set it for a
+	// provider that really checks addresses: a verified email is enough to sign in
+	// to an existing account with that email.
+	TrustEmail bool `yaml:"trustEmail" json:"trustEmail"`
+}
+
+// OIDCProvider verifies ID tokens from a generic OpenID Connect provider. Discovery
+// runs on first use and is retried until it succeeds, so a partner outage at
+// startup only affects that partner's logins.
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	verifier *IDTokenVerifier
+}
+
+// NewOIDCProvider returns the provider described by cfg
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Name implements IdentityProvider
+func (p *OIDCProvider) Name() string { return p.cfg.Name }
+
+// Verify implements IdentityProvider
+func (p *OIDCProvider) Verify(ctx context.Context, token string) (ExternalIdentity, error) {
+	verifier, err := p.discover(ctx)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	ext, err := verifier.Verify(ctx, token)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	// An untrusted partner's email is recorded but never links accounts
+	if !p.cfg.TrustEmail {
+		ext.EmailVerified = false
+	}
+	return ext, nil
+}
+
+// discoveryDocument is the part of the OpenID provider metadata we use
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// discover returns the token verifier, fetching the provider metadata on first
+// use. The fetch runs without the lock so a slow provider does not queue every
+// login behind it; concurrent first calls may each fetch, and the first to
+// finish is kept.
+func (p *OIDCProvider) discover(ctx context.Context) (*IDTokenVerifier, error) {
+	p.mu.Lock()
+	verifier := p.verifier
+	p.mu.Unlock()
+	if verifier != nil {
+		return verifier, nil
+	}
+
+	verifier, err := p.fetchVerifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.verifier == nil {
+		p.verifier = verifier
+	}
+	return p.verifier, nil
+}
+
+// fetchVerifier reads the discovery document and builds a verifier from it
+func (p *OIDCProvider) fetchVerifier(ctx context.Context) (*IDTokenVerifier, error) {
+	url := strings.TrimRight(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: discovery: %w", p.cfg.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: discovery: status %d", p.cfg.Name, resp.StatusCode)
+	}
+
+	var doc discoveryDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: discovery: %w", p.cfg.Name, err)
+	}
+	// The issuer must match exactly, or tokens from it would never verify
+	if doc.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%s: discovery: issuer %q does not match configured %q", p.cfg.Name, doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.JWKSURI == "" {
+		return nil, fmt.Errorf("%s: discovery: no jwks_uri", p.cfg.Name)
+	}
+
+	return &IDTokenVerifier{
+		Provider:  p.cfg.Name,
+		Issuers:   []string{doc.Issuer},
+		ClientIDs: p.cfg.ClientIDs,
+		Keys:      jwks.New(doc.JWKSURI, p.client),
+	}, nil
+}
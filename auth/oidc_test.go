@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"astromatch/auth/jwks/jwkstest"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newTestOIDC starts a discovery endpoint backed by a jwkstest key server and
+// returns a provider for it. handler, when set, serves the discovery document.
+func newTestOIDC(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, doc discoveryDocument)) (*OIDCProvider, *jwkstest.Server, *httptest.Server) {
+	t.Helper()
+	keys, err := jwkstest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(keys.Close)
+
+	var discovery *httptest.Server
+	discovery = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc := discoveryDocument{Issuer: discovery.URL, JWKSURI: keys.URL}
+		if handler != nil {
+			handler(w, r, doc)
+			return
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(discovery.Close)
+
+	p := NewOIDCProvider(OIDCConfig{Name: "acme", Issuer: discovery.URL, ClientIDs: []string{testClientID}})
+	return p, keys, discovery
+}
+
+// oidcToken signs a currently valid token with a verified email from issuer
+func oidcToken(t *testing.T, keys *jwkstest.Server, issuer string) string {
+	t.Helper()
+	claims := validClaims()
+	claims.Issuer = issuer
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	return sign(t, keys, claims)
+}
+
+func TestOIDCEmailTrust(t *testing.T) {
+	for _, trust := range []bool{false, true} {
+		p, keys, discovery := newTestOIDC(t, nil)
+		p.cfg.TrustEmail = trust
+
+		ext, err := p.Verify(context.Background(), oidcToken(t, keys, discovery.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ext.Provider != "acme" || ext.Email != "ada@example.com" {
+			t.Errorf("trust %v: got %+v", trust, ext)
+		}
+		if ext.EmailVerified != trust {
+			t.Errorf("trust %v: EmailVerified = %v", trust, ext.EmailVerified)
+		}
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	p, keys, discovery := newTestOIDC(t, func(w http.ResponseWriter, r *http.Request, doc discoveryDocument) {
+		doc.Issuer = "https://evil.example.com"
+		json.NewEncoder(w).Encode(doc)
+	})
+	if _, err := p.Verify(context.Background(), oidcToken(t, keys, discovery.URL)); err == nil {
+		t.Error("accepted a discovery document for another issuer")
+	}
+}
+
+func TestOIDCSlowDiscoveryDoesNotBlockOtherLogins(t *testing.T) {
+	stalled, release := make(chan struct{}), make(chan struct{})
+	var requests atomic.Int32
+	p, keys, discovery := newTestOIDC(t, func(w http.ResponseWriter, r *http.Request, doc discoveryDocument) {
+		if requests.Add(1) == 1 {
+			close(stalled)
+			<-release
+		}
+		json.NewEncoder(w).Encode(doc)
+	})
+	defer close(release)
+	token := oidcToken(t, keys, discovery.URL)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := p.Verify(context.Background(), token)
+		first <- err
+	}()
+	<-stalled
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Verify(context.Background(), token)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login waited for another login's discovery request")
+	}
+	select {
+	case <-first:
+		t.Fatal("the stalled discovery request returned early")
+	default:
+	}
+}
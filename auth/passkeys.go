@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"astromatch/cache"
+	"astromatch/db"
+	"astromatch/respond"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// passkeyCeremonyTTL bounds how long a registration or login may take between
+// its begin and finish requests
+const passkeyCeremonyTTL = 5 * time.Minute
+
+// maxPasskeyName is the longest device label accepted
+const maxPasskeyName = 64
+
+// Redis key prefixes for pending ceremonies
+const (
+	passkeyRegistrationKey = "webauthn:registration:"
+	passkeyLoginKey        = "webauthn:login:"
+)
+
+// webAuthn is the relying party, nil when passkeys are not configured
+var webAuthn *webauthn.WebAuthn
+
+// Pending ceremonies are kept in Redis so any instance can finish them;
+// tests replace these
+var (
+	saveCeremony = cache.SetJSON
+	// loadCeremony deletes the ceremony as it reads it
+	loadCeremony = cache.TakeJSON
+)
+
+// initWebAuthn configures passkeys from cfg; they stay off without a relying party ID
+func initWebAuthn(cfg Config) {
+	webAuthn = nil
+	if cfg.WebAuthnRPID == "" {
+		return
+	}
+	name := cfg.WebAuthnRPName
+	if name == "" {
+		name = mfaIssuer
+	}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: name,
+		RPOrigins:     cfg.WebAuthnOrigins,
+		// Passwordless login needs a discoverable credential unlocked by the user
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		log.Printf("Passkeys disabled: %v", err)
+		return
+	}
+	webAuthn = wa
+}
+
+// passkeyUser adapts an account and its passkeys to webauthn.User. The user
+// handle is the account ID, which is how discoverable logins find the account.
+type passkeyUser struct {
+	user     db.User
+	passkeys []db.Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte { return []byte(u.user.ID) }
+
+func (u passkeyUser) WebAuthnName() string {
+	switch {
+	case u.user.Email != "":
+		return u.user.Email
+	case u.user.Phone != "":
+		return u.user.Phone
+	}
+	return u.user.ID
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.WebAuthnName()
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, p := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(p.Transports))
+		for _, t := range p.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    p.UserPresent,
+				UserVerified:   p.UserVerified,
+				BackupEligible: p.BackupEligible,
+				BackupState:    p.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{AAGUID: p.AAGUID, SignCount: p.SignCount},
+		})
+	}
+	return credentials
+}
+
+func (h *Handler) loadPasskeyUser(ctx context.Context, userID string) (passkeyUser, error) {
+	user, err := h.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	passkeys, err := h.store.Passkeys.ListByUser(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	return passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// PasskeyFinishRequest completes a ceremony. Credential is the browser's
+// PublicKeyCredential as JSON.
+type PasskeyFinishRequest struct {
+	// SessionID identifies the login ceremony; registration is keyed by the account
+	SessionID  string          `json:"sessionId,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// passkeyLoginOptions starts a login; the client passes Options to navigator.credentials.get
+type passkeyLoginOptions struct {
+	SessionID string                        `json:"sessionId"`
+	Options   *protocol.CredentialAssertion `json:"options"`
+}
+
+// Passkeys lists (GET) the caller's passkeys
+func (h *Handler) Passkeys(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	h.listPasskeys(w, r, claims.UserID, http.StatusOK)
+}
+
+// Passkey removes (DELETE) one of the caller's passkeys
+func (h *Handler) Passkey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := h.store.Passkeys.Delete(r.Context(), claims.UserID, r.PathValue("passkeyID")); err != nil {
+		respond.DBError(w, r, err, "Failed to remove passkey")
+		return
+	}
+	log.Printf("Passkey %s removed by %s", r.PathValue("passkeyID"), claims.UserID)
+	h.listPasskeys(w, r, claims.UserID, http.StatusOK)
+}
+
+// BeginPasskeyRegistration (POST) returns the options the client passes to
+// navigator.credentials.create
+func (h *Handler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if !passkeyRequest(w, r) {
+		return
+	}
+
+	pu, err := h.loadPasskeyUser(r.Context(), claims.UserID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to start passkey registration")
+		return
+	}
+	// Authenticators refuse to register a second passkey for the same account
+	exclude := make([]protocol.CredentialDescriptor, 0, len(pu.passkeys))
+	for _, credential := range pu.WebAuthnCredentials() {
+		exclude = append(exclude, credential.Descriptor())
+	}
+
+	options, session, err := webAuthn.BeginRegistration(pu, webauthn.WithExclusions(exclude))
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to start passkey registration")
+		return
+	}
+	if err := saveCeremony(r.Context(), passkeyRegistrationKey+claims.UserID, session, passkeyCeremonyTTL); err != nil {
+		log.Printf("[%s] Failed to store passkey challenge: %v", respond.RequestID(r.Context()), err)
+		respond.Error(w, r, http.StatusServiceUnavailable, respond.CodeUnavailable, "Passkeys are temporarily unavailable")
+		return
+	}
+	respond.JSON(w, http.StatusOK, options)
+}
+
+// FinishPasskeyRegistration (POST) verifies the new credential and stores it
+func (h *Handler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if !passkeyRequest(w, r) {
+		return
+	}
+	var req PasskeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if len([]rune(name)) > maxPasskeyName {
+		verr := &db.ValidationError{}
+		verr.Add("name", "must be at most 64 characters")
+		respond.Validation(w, r, verr)
+		return
+	}
+	if name == "" {
+		name = "Passkey"
+	}
+
+	var session webauthn.SessionData
+	if !takeCeremony(w, r, passkeyRegistrationKey+claims.UserID, &session) {
+		return
+	}
+	pu, err := h.loadPasskeyUser(r.Context(), claims.UserID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to register passkey")
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid passkey response")
+		return
+	}
+	credential, err := webAuthn.CreateCredential(pu, session, parsed)
+	if err != nil {
+		log.Printf("[%s] Passkey registration failed for %s: %v", respond.RequestID(r.Context()), claims.UserID, err)
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Passkey could not be verified")
+		return
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	passkey := db.Passkey{
+		ID:              uuid.New().String(),
+		UserID:          claims.UserID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		UserPresent:     credential.Flags.UserPresent,
+		UserVerified:    credential.Flags.UserVerified,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now().UTC(),
+	}
+	if err := h.store.Passkeys.Create(r.Context(), passkey); err != nil {
+		respond.DBError(w, r, err, "Failed to register passkey")
+		return
+	}
+	log.Printf("Passkey %s registered by %s", passkey.ID, claims.UserID)
+	respond.JSON(w, http.StatusCreated, passkey)
+}
+
+// BeginPasskeyLogin (POST) starts a passwordless login. The account is not named
+// up front; the authenticator offers the passkeys it holds for this site.
+func (h *Handler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if !passkeyRequest(w, r) {
+		return
+	}
+	options, session, err := webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to start passkey login")
+		return
+	}
+
+	sessionID := uuid.New().String()
+	if err := saveCeremony(r.Context(), passkeyLoginKey+sessionID, session, passkeyCeremonyTTL); err != nil {
+		log.Printf("[%s] Failed to store passkey challenge: %v", respond.RequestID(r.Context()), err)
+		respond.Error(w, r, http.StatusServiceUnavailable, respond.CodeUnavailable, "Passkeys are temporarily unavailable")
+		return
+	}
+	respond.JSON(w, http.StatusOK, passkeyLoginOptions{SessionID: sessionID, Options: options})
+}
+
+// FinishPasskeyLogin (POST) verifies the assertion and starts the same session a
+// password login would. A passkey already proves possession and user
+// verification, so no further MFA challenge is issued.
+func (h *Handler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if !passkeyRequest(w, r) {
+		return
+	}
+	var req PasskeyFinishRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	var session webauthn.SessionData
+	if !takeCeremony(w, r, passkeyLoginKey+req.SessionID, &session) {
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid passkey response")
+		return
+	}
+
+	var owner passkeyUser
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		passkey, err := h.store.Passkeys.FindByCredentialID(r.Context(), rawID)
+		if err != nil {
+			return nil, err
+		}
+		if passkey.UserID != string(userHandle) {
+			return nil, errors.New("passkey belongs to another account")
+		}
+		owner, err = h.loadPasskeyUser(r.Context(), passkey.UserID)
+		return owner, err
+	}
+	_, credential, err := webAuthn.ValidatePasskeyLogin(lookup, session, parsed)
+	if err != nil {
+		log.Printf("[%s] Passkey login failed: %v", respond.RequestID(r.Context()), err)
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidCredentials, "Passkey could not be verified")
+		return
+	}
+
+	passkey, err := h.store.Passkeys.FindByCredentialID(r.Context(), credential.ID)
+	if err != nil {
+		respond.DBError(w, r, err, "Login failed")
+		return
+	}
+	// A counter that did not move means two authenticators hold this key
+	if credential.Authenticator.CloneWarning {
+		log.Printf("[%s] Passkey %s of %s rejected: sign counter %d did not increase past %d",
+			respond.RequestID(r.Context()), passkey.ID, passkey.UserID, credential.Authenticator.SignCount, passkey.SignCount)
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidCredentials, "This passkey can no longer be used; sign in another way and register it again")
+		return
+	}
+	if err := h.store.Passkeys.RecordUse(r.Context(), passkey.ID, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now().UTC()); err != nil {
+		respond.DBError(w, r, err, "Login failed")
+		return
+	}
+
+	if err := h.restoreAccount(r.Context(), owner.user); err != nil {
+		respond.DBError(w, r, err, "Login failed")
+		return
+	}
+	token, err := startSession(w, owner.user.ID)
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Token generation failed")
+		return
+	}
+	respond.JSON(w, http.StatusOK, map[string]string{"message": "Login successful", "token": token})
+}
+
+// passkeyRequest checks the method and that passkeys are configured, answering
+// the request when it cannot proceed
+func passkeyRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return false
+	}
+	if webAuthn == nil {
+		respond.Error(w, r, http.StatusServiceUnavailable, respond.CodeUnavailable, "Passkeys are not enabled")
+		return false
+	}
+	return true
+}
+
+// takeCeremony loads and consumes the pending ceremony under key, so each
+// challenge can be answered once
+func takeCeremony(w http.ResponseWriter, r *http.Request, key string, session *webauthn.SessionData) bool {
+	err := loadCeremony(r.Context(), key, session)
+	switch {
+	case errors.Is(err, cache.ErrMiss):
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Passkey request expired, please try again")
+		return false
+	case err != nil:
+		log.Printf("[%s] Failed to load passkey challenge: %v", respond.RequestID(r.Context()), err)
+		respond.Error(w, r, http.StatusServiceUnavailable, respond.CodeUnavailable, "Passkeys are temporarily unavailable")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) listPasskeys(w http.ResponseWriter, r *http.Request, userID string, status int) {
+	passkeys, err := h.store.Passkeys.ListByUser(r.Context(), userID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to list passkeys")
+		return
+	}
+	if passkeys == nil {
+		passkeys = []db.Passkey{}
+	}
+	respond.JSON(w, status, passkeys)
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"astromatch/cache"
+	"astromatch/db"
+	"astromatch/db/memory"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	testRPID   = "astromatch.test"
+	testOrigin = "https://astromatch.test"
+)
+
+// usePasskeys enables passkeys for testRPID and keeps ceremonies in a map that,
+// like Redis GETDEL, hands each one out once
+func usePasskeys(t *testing.T) {
+	t.Helper()
+	oldJWT, oldWebAuthn, oldSave, oldLoad := jwtKey, webAuthn, saveCeremony, loadCeremony
+	t.Cleanup(func() { jwtKey, webAuthn, saveCeremony, loadCeremony = oldJWT, oldWebAuthn, oldSave, oldLoad })
+	jwtKey = []byte("test-jwt-secret")
+	initWebAuthn(Config{WebAuthnRPID: testRPID, WebAuthnRPName: "AstroMatch", WebAuthnOrigins: []string{testOrigin}})
+	if webAuthn == nil {
+		t.Fatal("passkeys not enabled")
+	}
+
+	var mu sync.Mutex
+	ceremonies := map[string][]byte{}
+	saveCeremony = func(_ context.Context, key string, v interface{}, _ time.Duration) error {
+		data, err := json.Marshal(v)
+		mu.Lock()
+		defer mu.Unlock()
+		ceremonies[key] = data
+		return err
+	}
+	loadCeremony = func(_ context.Context, key string, v interface{}) error {
+		mu.Lock()
+		data, ok := ceremonies[key]
+		delete(ceremonies, key)
+		mu.Unlock()
+		if !ok {
+			return cache.ErrMiss
+		}
+		return json.Unmarshal(data, v)
+	}
+}
+
+// softAuthenticator is a platform authenticator holding one P-256 passkey
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+// authData builds authenticator data with user presence and verification set
+func (a *softAuthenticator) authData(attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested != nil {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge.String(), "origin": testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create with a "none" attestation
+func (a *softAuthenticator) create(t *testing.T, options protocol.CredentialCreation) json.RawMessage {
+	t.Helper()
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         1, // P-256
+		XCoord:        a.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credentialJSON(t, a.credentialID, map[string]string{
+		"clientDataJSON":    b64(clientData(t, "webauthn.create", options.Response.Challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get, naming userHandle as the account
+func (a *softAuthenticator) get(t *testing.T, options protocol.CredentialAssertion, userHandle string) json.RawMessage {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(nil)
+	client := clientData(t, "webauthn.get", options.Response.Challenge)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credentialJSON(t, a.credentialID, map[string]string{
+		"clientDataJSON":    b64(client),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64([]byte(userHandle)),
+	})
+}
+
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+func credentialJSON(t *testing.T, id []byte, response map[string]string) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"id": b64(id), "rawId": b64(id), "type": "public-key", "response": response})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// passkeyCall calls handler with body, as userID when it is not empty
+func passkeyCall(handler http.HandlerFunc, method, userID string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(method, "/", bytes.NewReader(data))
+	if userID != "" {
+		r = r.WithContext(WithClaims(r.Context(), &Claims{UserID: userID}))
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// newPasskeyTest returns a handler whose store holds ada and bob, with a passkey
+// registered to ada
+func newPasskeyTest(t *testing.T) (*Handler, *softAuthenticator, db.Passkey) {
+	t.Helper()
+	usePasskeys(t)
+	ctx := context.Background()
+	store := memory.NewStore()
+	for _, u := range []db.User{{ID: "ada", Email: "ada@example.com"}, {ID: "bob", Email: "bob@example.com"}} {
+		if err := store.Users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandler(store)
+
+	w := passkeyCall(h.BeginPasskeyRegistration, http.MethodPost, "ada", nil)
+	var options protocol.CredentialCreation
+	if err := json.NewDecoder(w.Body).Decode(&options); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("begin registration: status %d, %v", w.Code, err)
+	}
+	authenticator := newSoftAuthenticator(t)
+	w = passkeyCall(h.FinishPasskeyRegistration, http.MethodPost, "ada", PasskeyFinishRequest{Name: "Laptop", Credential: authenticator.create(t, options)})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("finish registration: status %d, body %s", w.Code, w.Body)
+	}
+	passkeys, err := store.Passkeys.ListByUser(ctx, "ada")
+	if err != nil || len(passkeys) != 1 {
+		t.Fatalf("registered passkeys: %v, %v", passkeys, err)
+	}
+	return h, authenticator, passkeys[0]
+}
+
+// beginLogin starts a passkey login and returns its session ID and options
+func beginLogin(t *testing.T, h *Handler) (string, protocol.CredentialAssertion) {
+	t.Helper()
+	w := passkeyCall(h.BeginPasskeyLogin, http.MethodPost, "", nil)
+	var begin struct {
+		SessionID string                       `json:"sessionId"`
+		Options   protocol.CredentialAssertion `json:"options"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&begin); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("begin login: status %d, %v", w.Code, err)
+	}
+	return begin.SessionID, begin.Options
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	h, authenticator, passkey := newPasskeyTest(t)
+	if passkey.Name != "Laptop" || !bytes.Equal(passkey.CredentialID, authenticator.credentialID) || !passkey.UserVerified {
+		t.Fatalf("registered %+v", passkey)
+	}
+
+	sessionID, options := beginLogin(t, h)
+	finish := PasskeyFinishRequest{SessionID: sessionID, Credential: authenticator.get(t, options, "ada")}
+	w := passkeyCall(h.FinishPasskeyLogin, http.MethodPost, "", finish)
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("login: status %d, body %s", w.Code, w.Body)
+	}
+	stored, err := h.store.Passkeys.FindByCredentialID(context.Background(), passkey.CredentialID)
+	if err != nil || stored.SignCount != 1 || stored.LastUsedAt == nil {
+		t.Errorf("use not recorded: %+v, %v", stored, err)
+	}
+
+	// Each challenge is answered once
+	w = passkeyCall(h.FinishPasskeyLogin, http.MethodPost, "", finish)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "expired") {
+		t.Errorf("replayed login: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestPasskeyCloneRejected(t *testing.T) {
+	h, authenticator, _ := newPasskeyTest(t)
+
+	sessionID, options := beginLogin(t, h)
+	if w := passkeyCall(h.FinishPasskeyLogin, http.MethodPost, "", PasskeyFinishRequest{SessionID: sessionID, Credential: authenticator.get(t, options, "ada")}); w.Code != http.StatusOK {
+		t.Fatalf("first login: status %d, body %s", w.Code, w.Body)
+	}
+
+	// A copy of the key signs with a counter that has not moved on
+	authenticator.signCount--
+	sessionID, options = beginLogin(t, h)
+	w := passkeyCall(h.FinishPasskeyLogin, http.MethodPost, "", PasskeyFinishRequest{SessionID: sessionID, Credential: authenticator.get(t, options, "ada")})
+	if w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("cloned passkey: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestPasskeyOfAnotherAccount(t *testing.T) {
+	h, authenticator, _ := newPasskeyTest(t)
+
+	// ada's passkey presented as bob's
+	sessionID, options := beginLogin(t, h)
+	w := passkeyCall(h.FinishPasskeyLogin, http.MethodPost, "", PasskeyFinishRequest{SessionID: sessionID, Credential: authenticator.get(t, options, "bob")})
+	if w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestPasskeyDeleteScopedToCaller(t *testing.T) {
+	h, _, passkey := newPasskeyTest(t)
+	remove := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, "/", nil)
+		r.SetPathValue("passkeyID", passkey.ID)
+		r = r.WithContext(WithClaims(r.Context(), &Claims{UserID: userID}))
+		w := httptest.NewRecorder()
+		h.Passkey(w, r)
+		return w
+	}
+
+	if w := remove("bob"); w.Code != http.StatusNotFound {
+		t.Errorf("bob removing ada's passkey: status %d", w.Code)
+	}
+	if passkeys, _ := h.store.Passkeys.ListByUser(context.Background(), "ada"); len(passkeys) != 1 {
+		t.Fatalf("ada's passkeys after bob's attempt: %v", passkeys)
+	}
+	if w := remove("ada"); w.Code != http.StatusOK {
+		t.Errorf("ada removing her passkey: status %d", w.Code)
+	}
+	if passkeys, _ := h.store.Passkeys.ListByUser(context.Background(), "ada"); len(passkeys) != 0 {
+		t.Errorf("passkey survived its owner's delete: %v", passkeys)
+	}
+}
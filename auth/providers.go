@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// IdentityProvider verifies tokens from an external sign-in provider. Clients sign
+// up, log in and link accounts by sending the provider's name and a token.
+type IdentityProvider interface {
+	// Name is the signupMethod clients send and the provider stored on identities
+	Name() string
+	// Verify checks token and returns the person it identifies
+	Verify(ctx context.Context, token string) (ExternalIdentity, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]IdentityProvider{}
+)
+
+// RegisterProvider makes p available under p.Name(), replacing any provider
+// registered under the same name
+func RegisterProvider(p IdentityProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// lookupProvider returns the provider registered as name
+func lookupProvider(name string) (IdentityProvider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// providerNames lists the registered providers, sorted
+func providerNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registerProviders replaces the registered providers with the ones cfg enables
+func registerProviders(cfg Config) {
+	providersMu.Lock()
+	providers = map[string]IdentityProvider{}
+	providersMu.Unlock()
+
+	if len(cfg.GoogleClientIDs) > 0 {
+		RegisterProvider(NewGoogleProvider(cfg.GoogleClientIDs, cfg.GoogleCertsURL))
+	}
+	if cfg.FacebookAppID != "" {
+		RegisterProvider(NewFacebookProvider(cfg.FacebookAppID, cfg.FacebookAppSecret, cfg.FacebookGraphURL))
+	}
+	if len(cfg.AppleClientIDs) > 0 {
+		RegisterProvider(NewAppleProvider(cfg.AppleClientIDs, cfg.AppleKeysURL))
+	}
+	for _, oidc := range cfg.OIDCProviders {
+		RegisterProvider(NewOIDCProvider(oidc))
+	}
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashOTPUsesDerivedKey(t *testing.T) {
+	oldJWT, oldOTP := jwtKey, otpKey
+	t.Cleanup(func() { jwtKey, otpKey = oldJWT, oldOTP })
+	jwtKey = []byte("test-jwt-secret")
+	otpKey = deriveKey(jwtKey, "otp")
+
+	hash := hashOTP("ada@example.com", "123456")
+	if hash != hashOTP("ada@example.com", "123456") {
+		t.Fatal("hashOTP is not deterministic")
+	}
+	if hash == hashOTP("bob@example.com", "123456") {
+		t.Error("the hash is not bound to the identifier")
+	}
+
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte("ada@example.com\x00123456"))
+	if hash == hex.EncodeToString(mac.Sum(nil)) {
+		t.Error("OTPs are hashed with the JWT signing key")
+	}
+}
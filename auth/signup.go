@@ -2,352 +2,245 @@ package auth
 
 import (
 	"astromatch/db"
+	"astromatch/respond"
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"google.golang.org/api/oauth2/v1"
-	"google.golang.org/api/option"
 )
 
 // SignupRequest struct to decode initial signup data
 type SignupRequest struct {
 	Token        string `json:"token"`
 	SignupMethod string `json:"signupMethod"`
+	// Name is the display name the client got from the provider, for providers
+	// whose tokens carry none
+	Name string `json:"name,omitempty"`
 }
 
-// SignupHandler handles the main signup flow
-func SignupHandler(w http.ResponseWriter, r *http.Request) {
+// SignupDetails are the account fields a client chooses when signing up with an
+// email or phone number. Everything else on db.User, such as the role, is set
+// by the server.
+type SignupDetails struct {
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Phone     string `json:"phone"`
+	Birthdate string `json:"birthdate,omitempty"`
+	Language  string `json:"language,omitempty"`
+}
+
+// newUser builds the account to create from details
+func (d SignupDetails) newUser(method string) db.User {
+	user := db.User{
+		ID:           uuid.New().String(),
+		Name:         d.Name,
+		Email:        d.Email,
+		Phone:        d.Phone,
+		Birthdate:    d.Birthdate,
+		Language:     d.Language,
+		SignupMethod: method,
+		// Only confirming the OTP verifies an account; matchmaking is closed until then
+		IsVerified: false,
+	}
+	// Phone accounts log in by OTP; a password sent along is never stored
+	if method == db.ProviderEmail {
+		user.Password = d.Password
+	}
+	return user
+}
+
+// Signup handles the main signup flow
+func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
+	// The body is decoded twice (method, then user data), so read it once
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
 	var req SignupRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
 		log.Printf("Failed to decode request body: %v", err)
 		return
 	}
 
 	switch req.SignupMethod {
 	case "email":
-		var creds db.User
-		err := json.NewDecoder(r.Body).Decode(&creds)
+		var details SignupDetails
+		err := json.Unmarshal(body, &details)
 		if err != nil {
-			http.Error(w, "Invalid user data", http.StatusBadRequest)
+			respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid user data")
 			return
 		}
-		handleEmailSignup(w, creds)
-	case "google":
-		handleGoogleSignupWithToken(w, req.Token)
-	case "facebook":
-		handleFacebookSignup(w, r)
+		h.handleEmailSignup(w, r, details.newUser(db.ProviderEmail))
 	case "phone":
-		var creds db.User
-		err := json.NewDecoder(r.Body).Decode(&creds)
+		var details SignupDetails
+		err := json.Unmarshal(body, &details)
 		if err != nil {
-			http.Error(w, "Invalid user data", http.StatusBadRequest)
+			respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid user data")
 			return
 		}
-		handlePhoneSignup(w, creds)
+		h.handlePhoneSignup(w, r, details.newUser(db.ProviderPhone))
 	default:
-		http.Error(w, "Invalid signup method", http.StatusBadRequest)
+		provider, ok := lookupProvider(req.SignupMethod)
+		if !ok {
+			respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid signup method")
+			return
+		}
+		h.handleProviderSignup(w, r, provider, req)
 	}
 }
 
-func handleGoogleSignupWithToken(w http.ResponseWriter, token string) {
-	user, err := VerifyGoogleTokenFromToken(token)
+// handleProviderSignup signs up or logs in with a token from an external provider
+func (h *Handler) handleProviderSignup(w http.ResponseWriter, r *http.Request, provider IdentityProvider, req SignupRequest) {
+	ext, err := provider.Verify(r.Context(), req.Token)
 	if err != nil {
-		http.Error(w, "Google signup failed", http.StatusUnauthorized)
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeInvalidToken, "Sign-in with "+provider.Name()+" failed")
 		log.Printf("Token verification failed: %v", err)
 		return
 	}
-
-	collection := db.Client.Database("astromatch").Collection("users")
-
-	var existingUser db.User
-	err = collection.FindOne(context.TODO(), bson.M{"email": user.Email}).Decode(&existingUser)
-	if err == nil {
-		// User already exists – login flow
-		log.Printf("User already exists, logging in: %s", existingUser.Email)
-
-		// Create JWT token for session (or session-based logic)
-		tokenString, err := GenerateJWT(user.ID)
-		if err != nil {
-			http.Error(w, "Failed to generate login token", http.StatusInternalServerError)
-			return
-		}
-
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "User logged in successfully",
-			"token":   tokenString,
-		})
-		return
+	// Apple gives the client the name once, outside the token
+	if ext.Name == "" {
+		ext.Name = req.Name
 	}
+	h.externalSignIn(w, r, ext, "User registered successfully")
+}
 
-	// New user registration flow
-	user.ID = uuid.New().String()
-	user.SignupMethod = "google"
-	user.IsVerified = true
-
-	_, err = collection.InsertOne(context.TODO(), user)
+// externalSignIn logs in, links or registers the account for an OAuth identity
+func (h *Handler) externalSignIn(w http.ResponseWriter, r *http.Request, ext ExternalIdentity, registeredMessage string) {
+	ctx := r.Context()
+	user, created, err := h.signInWithIdentity(ctx, ext)
 	if err != nil {
-		http.Error(w, "User creation failed", http.StatusInternalServerError)
+		respond.DBError(w, r, err, "Sign-in failed")
 		return
 	}
 
-	tokenString, err := GenerateJWT(user.ID)
+	message := registeredMessage
+	if !created {
+		log.Printf("User already exists, logging in: %s", user.ID)
+		if h.requireMFA(w, r, user) {
+			return
+		}
+		if err := h.restoreAccount(ctx, user); err != nil {
+			respond.DBError(w, r, err, "Login failed")
+			return
+		}
+		message = "User logged in successfully"
+	}
+
+	tokenString, err := startSession(w, user.ID)
 	if err != nil {
-		http.Error(w, "Failed to generate login token", http.StatusInternalServerError)
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to generate login token")
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Google user registered successfully",
+		"message": message,
 		"token":   tokenString,
 	})
 }
 
-// VerifyGoogleTokenFromToken verifies the token and extracts user info from the ID token (JWT)
-func VerifyGoogleTokenFromToken(token string) (db.User, error) {
-	ctx := context.Background()
-
-	// Create OAuth2 service for token verification
-	oauth2Service, err := oauth2.NewService(ctx, option.WithoutAuthentication())
-	if err != nil {
-		log.Printf("Failed to initialize OAuth2 service: %v", err)
-		return db.User{}, err
-	}
-
-	tokenInfoService := oauth2Service.Tokeninfo()
-	tokenInfoService.IdToken(token) // Verify token validity
-
-	_, err = tokenInfoService.Do()
-	if err != nil {
-		log.Printf("Token verification failed: %v", err)
-		return db.User{}, errors.New("invalid Google token")
-	}
-
-	// Decode the ID token (JWT) to extract user info
-	claims, err := decodeGoogleIDToken(token)
-	if err != nil {
-		log.Printf("Failed to decode ID token: %v", err)
-		return db.User{}, errors.New("failed to extract user info")
-	}
-
-	user := db.User{
-		Name:       claims["name"].(string),
-		Email:      claims["email"].(string),
-		ProfilePic: claims["picture"].(string),
-		IsVerified: true,
-	}
-
-	return user, nil
-}
-
-// decodeGoogleIDToken decodes the JWT and extracts user claims (email, name, picture)
-func decodeGoogleIDToken(idToken string) (map[string]interface{}, error) {
-	// Split the JWT (Header.Payload.Signature)
-	parts := strings.Split(idToken, ".")
-	if len(parts) < 2 {
-		return nil, errors.New("invalid JWT format")
-	}
-
-	// Base64 decode the payload
-	payload, err := jwt.DecodeSegment(parts[1])
-	if err != nil {
-		return nil, err
-	}
-
-	// Unmarshal payload into a map
-	var claims map[string]interface{}
-	err = json.Unmarshal(payload, &claims)
-	if err != nil {
-		return nil, err
-	}
-
-	// Check for required fields
-	if claims["email"] == nil || claims["name"] == nil || claims["picture"] == nil {
-		return nil, errors.New("incomplete token claims")
-	}
-
-	return claims, nil
-}
-
 // handleEmailSignup manages email-based signup with password hashing
-func handleEmailSignup(w http.ResponseWriter, creds db.User) {
-	if db.Client == nil {
-		http.Error(w, "Database not initialized", http.StatusInternalServerError)
-		log.Println("MongoDB client is nil")
-		return
-	}
-
-	collection := db.Client.Database("astromatch").Collection("users")
-	ctx := context.TODO()
-
-	// Check if email already exists
-	var existingUser db.User
-	err := collection.FindOne(ctx, bson.M{"email": creds.Email}).Decode(&existingUser)
-	if err == nil {
-		http.Error(w, "Email already exists", http.StatusConflict)
-		return
-	}
+func (h *Handler) handleEmailSignup(w http.ResponseWriter, r *http.Request, creds db.User) {
+	ctx := r.Context()
 
 	// Hash password
 	hashedPassword, err := HashPassword(creds.Password)
 	if err != nil {
-		http.Error(w, "Password hashing failed", http.StatusInternalServerError)
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Password hashing failed")
 		return
 	}
 
-	// Assign UUID and hash password
-	creds.ID = uuid.New().String()
 	creds.Password = hashedPassword
 
-	// Insert new user into MongoDB
-	_, err = collection.InsertOne(ctx, creds)
-	if err != nil {
-		http.Error(w, "Failed to insert user data", http.StatusInternalServerError)
-		log.Printf("Failed to insert user data into the database: %v", err)
-		return
-	}
-
-	// Generate and send OTP
-	otp := GenerateOTP()
-	err = SendOTPViaEmail(creds.Email, otp)
-	if err != nil {
-		http.Error(w, "Failed to send OTP", http.StatusInternalServerError)
+	// Insert new user; the unique email index rejects duplicates
+	err = h.store.Users.Create(ctx, creds)
+	if errors.Is(err, db.ErrDuplicateUser) {
+		respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "Email already exists")
 		return
 	}
-
-	// Cache OTP or store in MongoDB
-	storeOTP(creds.Email, otp)
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
-}
-
-// // handleGoogleSignup manages Google OAuth signup
-func handleGoogleSignup(w http.ResponseWriter, r *http.Request) {
-	user, err := VerifyGoogleToken(r)
 	if err != nil {
-		http.Error(w, "Google signup failed", http.StatusUnauthorized)
+		respond.DBError(w, r, err, "Failed to insert user data")
+		log.Printf("Failed to insert user data into the database: %v", err)
 		return
 	}
 
-	collection := db.Client.Database("astromatch").Collection("users")
-
-	// Check if user already exists by email
-	var existingUser db.User
-	err = collection.FindOne(context.TODO(), bson.M{"email": user.Email}).Decode(&existingUser)
-	if err == nil {
-		http.Error(w, "User already exists with this email", http.StatusConflict)
-		return
-	}
+	h.addIdentity(ctx, creds.ID, db.ProviderEmail, creds.Email)
 
-	user.ID = uuid.New().String()
-	_, err = collection.InsertOne(context.TODO(), user)
+	// Generate and send OTP
+	otp, err := GenerateOTP()
 	if err != nil {
-		http.Error(w, "User creation failed", http.StatusInternalServerError)
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to generate OTP")
 		return
 	}
-
-	json.NewEncoder(w).Encode(map[string]string{"message": "Google user registered successfully"})
-}
-
-// handleFacebookSignup manages Facebook OAuth signup
-func handleFacebookSignup(w http.ResponseWriter, r *http.Request) {
-	user, err := VerifyFacebookToken(r)
+	err = SendOTPViaEmail(creds.Email, otp, creds.Language)
 	if err != nil {
-		http.Error(w, "Facebook signup failed", http.StatusUnauthorized)
+		respond.Error(w, r, http.StatusBadGateway, respond.CodeUpstreamFailed, "Failed to send OTP")
 		return
 	}
 
-	collection := db.Client.Database("astromatch").Collection("users")
+	// Store OTP for verification
+	h.storeOTP(ctx, creds.Email, otp)
 
-	// Check if user already exists by email
-	var existingUser db.User
-	err = collection.FindOne(context.TODO(), bson.M{"email": user.Email}).Decode(&existingUser)
-	if err == nil {
-		http.Error(w, "User already exists with this email", http.StatusConflict)
-		return
-	}
-
-	user.ID = uuid.New().String()
-	_, err = collection.InsertOne(context.TODO(), user)
-	if err != nil {
-		http.Error(w, "User creation failed", http.StatusInternalServerError)
-		return
-	}
-
-	json.NewEncoder(w).Encode(map[string]string{"message": "Facebook user registered successfully"})
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
 }
 
 // handlePhoneSignup handles phone-based signup
-func handlePhoneSignup(w http.ResponseWriter, creds db.User) {
-	collection := db.Client.Database("astromatch").Collection("users")
-	ctx := context.TODO()
+func (h *Handler) handlePhoneSignup(w http.ResponseWriter, r *http.Request, creds db.User) {
+	ctx := r.Context()
 
-	// Check if phone already exists
-	var existingUser db.User
-	err := collection.FindOne(ctx, bson.M{"phone": creds.Phone}).Decode(&existingUser)
-	if err == nil {
-		http.Error(w, "Phone number already exists", http.StatusConflict)
+	// The unique phone index rejects duplicates
+	err := h.store.Users.Create(ctx, creds)
+	if errors.Is(err, db.ErrDuplicateUser) {
+		respond.Error(w, r, http.StatusConflict, respond.CodeConflict, "Phone number already exists")
 		log.Printf("Phone number already exists: %s", creds.Phone)
 		return
-	} else if err != mongo.ErrNoDocuments {
-		http.Error(w, "Database query error", http.StatusInternalServerError)
-		log.Printf("Error querying phone number: %v", err)
-		return
 	}
-
-	creds.ID = uuid.New().String()
-
-	_, err = collection.InsertOne(ctx, creds)
 	if err != nil {
-		// Log full error details if insertion fails
 		log.Printf("Failed to insert phone user - Full Error: %+v", err)
-
-		// Handle duplicate key error
-		if mongo.IsDuplicateKeyError(err) {
-			http.Error(w, "Phone number already exists", http.StatusConflict)
-			log.Printf("Duplicate key error during insertion: %v", err)
-		} else {
-			http.Error(w, "Failed to insert phone user", http.StatusInternalServerError)
-		}
+		respond.DBError(w, r, err, "Failed to insert phone user")
 		return
 	}
+	h.addIdentity(ctx, creds.ID, db.ProviderPhone, creds.Phone)
+
 	// Generate and send OTP
-	otp := GenerateOTP()
+	otp, err := GenerateOTP()
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to generate OTP")
+		return
+	}
 	err = SendOTPViaPhone(creds.Phone, otp)
 	if err != nil {
-		http.Error(w, "Failed to send OTP", http.StatusInternalServerError)
+		respond.Error(w, r, http.StatusBadGateway, respond.CodeUpstreamFailed, "Failed to send OTP")
 		return
 	}
 
-	// Cache OTP or store in MongoDB
-	storeOTP(creds.Phone, otp)
+	// Store OTP for verification
+	h.storeOTP(ctx, creds.Phone, otp)
 
 	json.NewEncoder(w).Encode(map[string]string{"message": "OTP sent. Verify to complete signup."})
 }
 
-// storeOTP caches the OTP or saves it to MongoDB for verification
-func storeOTP(identifier, otp string) {
-	collection := db.Client.Database("astromatch").Collection("user_otp")
-	ctx := context.TODO()
-
-	// Store OTP with expiration in the database
-	_, err := collection.InsertOne(ctx, bson.M{
-		"identifier": identifier,
-		"otp":        otp,
-		"expires_at": time.Now().Add(5 * time.Minute),
+// storeOTP saves a hash of the OTP with a 5 minute expiry for verification. It
+// replaces any earlier code for identifier, so resending does not add guesses.
+func (h *Handler) storeOTP(ctx context.Context, identifier, otp string) {
+	if err := h.store.OTPs.DeleteByIdentifier(ctx, identifier); err != nil {
+		log.Printf("Failed to clear earlier OTPs for %s: %v", identifier, err)
+	}
+	err := h.store.OTPs.Save(ctx, db.UserOTP{
+		Identifier: identifier,
+		CodeHash:   hashOTP(identifier, otp),
+		ExpiresAt:  time.Now().Add(5 * time.Minute),
 	})
-
 	if err != nil {
 		log.Printf("Failed to store OTP for %s: %v", identifier, err)
 	}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238), the defaults every authenticator app supports
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpSkew is how many steps either side of now are accepted, for clock drift
+	totpSkew = 1
+	// totpSecretSize is 160 bits, the size RFC 4226 recommends
+	totpSecretSize = 20
+)
+
+// Recovery codes are handed out in batches, each usable once
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 5
+)
+
+// errMFAUnavailable means no encryption key is configured
+var errMFAUnavailable = errors.New("two-factor authentication is not configured")
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// DecodeMFAKey parses the base64 encoded 32 byte MFA encryption key
+func DecodeMFAKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+// newTOTPSecret returns a random secret
+func newTOTPSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretSize)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// totpCode is the code for secret at time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// totpStep is the time step now falls in
+func totpStep(now time.Time) int64 {
+	return now.Unix() / int64(totpPeriod/time.Second)
+}
+
+// matchTOTP returns the step code is valid for around now. Steps at or before
+// lastStep are refused so an observed code cannot be replayed.
+func matchTOTP(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// provisioningURI is the otpauth:// URI authenticator apps scan as a QR code
+func provisioningURI(account string, secret []byte) string {
+	label := url.PathEscape(mfaIssuer + ":" + account)
+	params := url.Values{
+		"secret":    {base32NoPad.EncodeToString(secret)},
+		"issuer":    {mfaIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(int(totpPeriod / time.Second))},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// sealSecret encrypts a TOTP secret for storage. userID is bound in as
+// additional data, so a secret copied onto another account does not decrypt.
+func sealSecret(secret []byte, userID string) (string, error) {
+	gcm, err := mfaCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, secret, []byte(userID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts a secret stored by sealSecret
+func openSecret(sealed, userID string) ([]byte, error) {
+	gcm, err := mfaCipher()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed secret too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, []byte(userID))
+}
+
+func mfaCipher() (cipher.AEAD, error) {
+	if mfaKey == nil {
+		return nil, errMFAUnavailable
+	}
+	block, err := aes.NewCipher(mfaKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newRecoveryCodes returns a batch of codes to show the user once, and the
+// hashes to store
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	if mfaKey == nil {
+		return nil, nil, errMFAUnavailable
+	}
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPad.EncodeToString(raw))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode is keyed with the MFA key, so stolen hashes of these short
+// codes cannot be brute forced without it as well
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	mac := hmac.New(sha256.New, mfaKey)
+	mac.Write([]byte(normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchRecoveryCode returns the index of code in hashes, or -1
+func matchRecoveryCode(hashes []string, code string) int {
+	if mfaKey == nil || strings.TrimSpace(code) == "" {
+		return -1
+	}
+	hash := []byte(hashRecoveryCode(code))
+	match := -1
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), hash) == 1 {
+			match = i
+		}
+	}
+	return match
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"crypto/hmac"
 	"encoding/json"
 	"errors"
 	"log"
@@ -10,9 +11,8 @@ import (
 	"time"
 
 	"astromatch/db"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"astromatch/notify"
+	"astromatch/respond"
 )
 
 // VerifyUserRequest struct handles incoming OTP verification requests
@@ -22,117 +22,205 @@ type VerifyUserRequest struct {
 	OTP   string `json:"otp"`
 }
 
-// VerifyUserHandler verifies a user using email or phone and OTP
-func VerifyUserHandler(w http.ResponseWriter, r *http.Request) {
-	if db.Client == nil {
-		http.Error(w, "MongoDB client not initialized", http.StatusInternalServerError)
+// VerifyUser verifies a user using email or phone and OTP
+func (h *Handler) VerifyUser(w http.ResponseWriter, r *http.Request) {
+	var req VerifyUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request payload")
 		return
 	}
 
-	var req VerifyUserRequest
+	if err := validateRequest(req); err != nil {
+		respond.Validation(w, r, err)
+		return
+	}
+
+	if err := h.verifyUser(r.Context(), req); err != nil {
+		if errors.Is(err, errInvalidOTP) {
+			respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidOTP, "Invalid or expired OTP")
+			return
+		}
+		respond.DBError(w, r, err, "Failed to update user status")
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, map[string]string{"message": "User verified successfully."})
+}
+
+// ResendVerificationRequest names the email or phone number to send a new code to
+type ResendVerificationRequest struct {
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+// resendAccepted is the answer whether or not a code was sent, so the endpoint
+// does not reveal which accounts exist or still need verifying
+const resendAccepted = "If this account still needs verifying, a new code is on its way."
+
+// ResendVerification (POST) sends a fresh OTP to an unverified account
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ResendVerificationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	verr := &db.ValidationError{}
+	validateIdentifier(verr, req.Email, req.Phone)
+	if len(verr.Fields) > 0 {
+		respond.Validation(w, r, verr)
 		return
 	}
 
-	if err := validateRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	ctx := r.Context()
+	var user db.User
+	var err error
+	if req.Email != "" {
+		user, err = h.store.Users.GetByEmail(ctx, req.Email)
+	} else {
+		user, err = h.store.Users.GetByPhone(ctx, req.Phone)
+	}
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		respond.DBError(w, r, err, "Failed to resend verification")
+		return
+	}
+	if err != nil || user.IsVerified || user.DeletedAt != nil {
+		respond.JSON(w, http.StatusAccepted, map[string]string{"message": resendAccepted})
 		return
 	}
 
-	if err := verifyUser(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	otp, err := GenerateOTP()
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to generate OTP")
 		return
 	}
+	identifier := req.Email
+	if req.Email != "" {
+		err = SendOTPViaEmail(req.Email, otp, user.Language)
+	} else {
+		identifier = req.Phone
+		err = SendOTPViaPhone(req.Phone, otp)
+	}
+	if err != nil {
+		respond.Error(w, r, http.StatusBadGateway, respond.CodeUpstreamFailed, "Failed to send OTP")
+		return
+	}
+	h.storeOTP(ctx, identifier, otp)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "User verified successfully."})
+	respond.JSON(w, http.StatusAccepted, map[string]string{"message": resendAccepted})
 }
 
+// errInvalidOTP is returned when no matching, unexpired OTP exists
+var errInvalidOTP = errors.New("invalid or expired OTP")
+
 // validateRequest validates email/phone and OTP
-func validateRequest(req VerifyUserRequest) error {
-	if req.Email == "" && req.Phone == "" {
-		return errors.New("either email or phone is required")
-	}
+func validateRequest(req VerifyUserRequest) *db.ValidationError {
+	verr := &db.ValidationError{}
+	validateIdentifier(verr, req.Email, req.Phone)
 	if req.OTP == "" {
-		return errors.New("OTP is required")
+		verr.Add("otp", "OTP is required")
 	}
 
-	if req.Email != "" {
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return verr
+}
+
+// validateIdentifier checks that an email or phone number is given and well formed
+func validateIdentifier(verr *db.ValidationError, email, phone string) {
+	if email == "" && phone == "" {
+		verr.Add("email", "either email or phone is required")
+	}
+
+	if email != "" {
 		emailRegex := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
-		if match, _ := regexp.MatchString(emailRegex, req.Email); !match {
-			return errors.New("invalid email format")
+		if match, _ := regexp.MatchString(emailRegex, email); !match {
+			verr.Add("email", "invalid email format")
 		}
 	}
 
-	if req.Phone != "" {
+	if phone != "" {
 		phoneRegex := `^\d{10,15}$`
-		if match, _ := regexp.MatchString(phoneRegex, req.Phone); !match {
-			return errors.New("invalid phone number")
+		if match, _ := regexp.MatchString(phoneRegex, phone); !match {
+			verr.Add("phone", "invalid phone number")
 		}
 	}
-
-	return nil
 }
 
-// verifyUser handles OTP verification and updates the user's isVerified flag
-func verifyUser(req VerifyUserRequest) error {
-
-	collection := db.Client.Database("astromatch").Collection("user_otp")
+// verifyUser handles OTP verification and updates the user's verified flag
+func (h *Handler) verifyUser(ctx context.Context, req VerifyUserRequest) error {
+	identifier := req.Email
+	if identifier == "" {
+		identifier = req.Phone
+	}
 
 	// Convert current time to UTC and truncate precision
 	now := time.Now().UTC().Truncate(time.Second)
 
-	// Log all OTPs for the identifier to check if the record exists
-	cursor, _ := collection.Find(context.TODO(), bson.M{"identifier": req.Email})
-	var otpResults []db.UserOTP
-	if err := cursor.All(context.TODO(), &otpResults); err == nil {
-		log.Printf("All OTPs for identifier %s: %+v", req.Email, otpResults)
+	// Each send replaces the earlier code, but compare against every unexpired
+	// OTP in case two sends raced
+	otps, err := h.store.OTPs.ListValid(ctx, identifier, now)
+	if err != nil {
+		return err
 	}
-
-	// Create the filter for OTP lookup
-	filter := bson.D{
-		{Key: "otp", Value: req.OTP},
-		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}},
-		{Key: "identifier", Value: req.Email},
+	want := hashOTP(identifier, req.OTP)
+	matched := false
+	for _, stored := range otps {
+		if hmac.Equal([]byte(stored.CodeHash), []byte(want)) {
+			matched = true
+		}
+	}
+	if !matched {
+		log.Printf("OTP verification failed for %s", identifier)
+		return errInvalidOTP
 	}
 
-	log.Printf("Running Query for identifier: %s, OTP: %s, Expires at (greater than): %v", req.Email, req.OTP, now)
-
-	// Perform the query to fetch the OTP
-	var userOTP db.UserOTP
-	err := collection.FindOne(context.TODO(), filter).Decode(&userOTP)
+	// Update user's verification status
+	var user db.User
+	if req.Email != "" {
+		user, err = h.store.Users.GetByEmail(ctx, req.Email)
+	} else {
+		user, err = h.store.Users.GetByPhone(ctx, req.Phone)
+	}
 	if err != nil {
-		log.Printf("OTP Verification failed. Filter used: %v | Error: %v", filter, err)
-		return errors.New("invalid or expired OTP")
+		log.Printf("No user found to verify for %s: %v", identifier, err)
+		return err
+	}
+
+	if err := h.store.Users.SetVerified(ctx, user.ID); err != nil {
+		log.Printf("Failed to update user verification status for %s. Error: %v", identifier, err)
+		return err
 	}
 
-	// Log the result of the comparison
-	isValid := userOTP.ExpiresAt.After(now)
-	log.Printf("OTP Found. Expires at: %v | Current time (UTC): %v | Expires at > now: %v", userOTP.ExpiresAt, now, isValid)
+	provider := db.ProviderEmail
+	if req.Email == "" {
+		provider = db.ProviderPhone
+	}
+	if err := h.store.Identities.MarkVerified(ctx, provider, identifier); err != nil && !errors.Is(err, db.ErrIdentityNotFound) {
+		log.Printf("Failed to mark %s identity verified for %s: %v", provider, identifier, err)
+	}
 
-	if !isValid {
-		return errors.New("otp has expired")
+	// The OTP is single-use
+	if err := h.store.OTPs.DeleteByIdentifier(ctx, identifier); err != nil {
+		log.Printf("Failed to clear OTPs for %s: %v", identifier, err)
 	}
 
-	// Update user's verification status
-	usersCollection := db.Client.Database("astroMatch").Collection("users")
+	log.Printf("User marked as verified for %s.", identifier)
 
-	updateFilter := bson.D{}
 	if req.Email != "" {
-		updateFilter = append(updateFilter, bson.E{Key: "email", Value: req.Email})
-	} else {
-		updateFilter = append(updateFilter, bson.E{Key: "phone", Value: req.Phone})
+		sendWelcomeEmail(user)
 	}
-	updateFilter = append(updateFilter, bson.E{Key: "isverified", Value: false})
+	return nil
+}
 
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "isverified", Value: true}}}}
-	_, err = usersCollection.UpdateOne(context.TODO(), updateFilter, update, options.Update())
+// sendWelcomeEmail greets a newly verified user in their preferred language
+func sendWelcomeEmail(user db.User) {
+	err := notify.SendTemplate(user.Email, notify.TemplateWelcome, user.Language, notify.WelcomeEmail{Name: user.Name})
 	if err != nil {
-		log.Printf("Failed to update user verification status for %s. Error: %v", req.Email, err)
-		return errors.New("failed to update user status")
+		log.Printf("Failed to send welcome email to %s: %v", user.Email, err)
 	}
-
-	log.Printf("User marked as verified for %s.", req.Email)
-	return nil
 }
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"astromatch/db"
+	"astromatch/db/memory"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResentOTPReplacesEarlierCode(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	if err := store.Users.Create(ctx, db.User{ID: "ada", Phone: "15550000001"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(store)
+
+	h.storeOTP(ctx, "15550000001", "111111")
+	h.storeOTP(ctx, "15550000001", "222222")
+
+	if err := h.verifyUser(ctx, VerifyUserRequest{Phone: "15550000001", OTP: "111111"}); !errors.Is(err, errInvalidOTP) {
+		t.Fatalf("earlier code: got %v, want errInvalidOTP", err)
+	}
+	if err := h.verifyUser(ctx, VerifyUserRequest{Phone: "15550000001", OTP: "222222"}); err != nil {
+		t.Fatalf("latest code: %v", err)
+	}
+	if user, _ := store.Users.GetByID(ctx, "ada"); !user.IsVerified {
+		t.Error("user not verified")
+	}
+	if err := h.verifyUser(ctx, VerifyUserRequest{Phone: "15550000001", OTP: "222222"}); !errors.Is(err, errInvalidOTP) {
+		t.Errorf("code reused: got %v, want errInvalidOTP", err)
+	}
+}
@@ -1,24 +1,144 @@
-
 package cache
 
 import (
-    "github.com/go-redis/redis/v8"
-    "context"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
+// Config holds Redis connection settings
+type Config struct {
+	Addr     string `yaml:"addr" json:"addr" env:"REDIS_ADDR"`
+	Password string `yaml:"password" json:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" json:"db" env:"REDIS_DB"`
+}
+
 var ctx = context.Background()
 var rdb *redis.Client
 
-func InitRedis() {
-    rdb = redis.NewClient(&redis.Options{
-        Addr: "localhost:6379",
-    })
+// ErrMiss means the key does not exist or has expired
+var ErrMiss = errors.New("cache: miss")
+
+var errNotInitialized = errors.New("redis client not initialized")
+
+func InitRedis(cfg Config) {
+	rdb = redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+}
+
+// Ping checks that Redis is reachable
+func Ping(c context.Context) error {
+	if rdb == nil {
+		return errNotInitialized
+	}
+	return rdb.Ping(c).Err()
+}
+
+// Close releases the Redis connection pool
+func Close() error {
+	if rdb == nil {
+		return nil
+	}
+	return rdb.Close()
 }
 
 func SetSession(key, value string) error {
-    return rdb.Set(ctx, key, value, 0).Err()
+	return rdb.Set(ctx, key, value, 0).Err()
 }
 
 func GetSession(key string) (string, error) {
-    return rdb.Get(ctx, key).Result()
+	return rdb.Get(ctx, key).Result()
+}
+
+// SetJSON stores v as JSON under key, expiring after ttl
+func SetJSON(c context.Context, key string, v interface{}, ttl time.Duration) error {
+	if rdb == nil {
+		return errNotInitialized
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(c, key, data, ttl).Err()
+}
+
+// TakeJSON decodes the JSON value under key into v and deletes it in the same
+// step, so a value such as a one-time challenge can be used only once
+func TakeJSON(c context.Context, key string, v interface{}) error {
+	if rdb == nil {
+		return errNotInitialized
+	}
+	data, err := rdb.GetDel(c, key).Bytes()
+	if err == redis.Nil {
+		return ErrMiss
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// slidingWindow trims events older than the window from a sorted set of
+// millisecond timestamps, adds one if fewer than limit remain, and returns
+// whether it did along with the remaining timestamps, oldest first
+var slidingWindow = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local added = 0
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	added = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+return {added, redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')}
+`)
+
+// TakeSlot records an event at now under key if fewer than limit events fall
+// within the trailing window, atomically across instances. It reports whether
+// the event was recorded and returns the events in the window, oldest first. A
+// limit of zero only reads.
+func TakeSlot(c context.Context, key string, limit int, window time.Duration, now time.Time) (bool, []time.Time, error) {
+	if rdb == nil {
+		return false, nil, errNotInitialized
+	}
+	// The member only has to be unique; the score carries the time
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+	res, err := slidingWindow.Run(c, rdb, []string{key}, now.UnixMilli(), window.Milliseconds(), limit, member).Slice()
+	if err != nil {
+		return false, nil, err
+	}
+	if len(res) != 2 {
+		return false, nil, fmt.Errorf("cache: unexpected sliding window reply %v", res)
+	}
+	added, _ := res[0].(int64)
+	pairs, _ := res[1].([]interface{})
+	events := make([]time.Time, 0, len(pairs)/2)
+	for i := 1; i < len(pairs); i += 2 {
+		score, _ := pairs[i].(string)
+		ms, err := strconv.ParseFloat(score, 64)
+		if err != nil {
+			return false, nil, fmt.Errorf("cache: bad sliding window score %q", score)
+		}
+		events = append(events, time.UnixMilli(int64(ms)))
+	}
+	return added == 1, events, nil
+}
+
+// Delete removes keys
+func Delete(c context.Context, keys ...string) error {
+	if rdb == nil {
+		return errNotInitialized
+	}
+	return rdb.Del(c, keys...).Err()
 }
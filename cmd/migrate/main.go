@@ -0,0 +1,73 @@
+// Command migrate applies or lists database migrations.
+//
+//	go run ./cmd/migrate [-config path] [up|status|indexes]
+package main
+
+import (
+	"astromatch/config"
+	"astromatch/db"
+	"astromatch/db/migrate"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file")
+	timeout := flag.Duration("timeout", 10*time.Minute, "maximum time to run migrations")
+	flag.Parse()
+
+	cfg, err := config.Read(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Mongo.URI == "" {
+		log.Fatal("config: MONGO_URI is required")
+	}
+
+	db.InitDB(cfg.Mongo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	defer db.Close(context.Background())
+
+	command := flag.Arg(0)
+	if command == "" {
+		command = "up"
+	}
+
+	switch command {
+	case "up":
+		ran, err := migrate.Up(ctx, db.Client, db.Database())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", len(ran))
+	case "status":
+		statuses, err := migrate.List(ctx, db.Database())
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%03d  %-32s %s\n", s.Version, s.Name, state)
+		}
+	case "indexes":
+		if err := db.EnsureIndexes(ctx, db.Database()); err != nil {
+			log.Fatal(err)
+		}
+		drift, err := db.CheckIndexes(ctx, db.Database())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(drift)
+	default:
+		log.Fatalf("unknown command %q (use up, status or indexes)", command)
+	}
+}
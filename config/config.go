@@ -0,0 +1,329 @@
+package config
+
+import (
+	"astromatch/account"
+	"astromatch/api"
+	"astromatch/auth"
+	"astromatch/cache"
+	"astromatch/db"
+	"astromatch/notify"
+	"astromatch/photos"
+	"astromatch/ratelimit"
+	"astromatch/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServerConfig holds HTTP server settings
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" json:"addr" env:"HTTP_ADDR"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" json:"readTimeout" env:"HTTP_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" json:"readHeaderTimeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" json:"writeTimeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" json:"idleTimeout" env:"HTTP_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" json:"shutdownTimeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+	// DrainDelay is how long /readyz fails before the server stops accepting
+	// requests, so load balancers take the instance out of rotation first
+	DrainDelay time.Duration `yaml:"drainDelay" json:"drainDelay" env:"HTTP_DRAIN_DELAY"`
+}
+
+// Config is the full application configuration, split into one section per subsystem
+type Config struct {
+	Server  ServerConfig   `yaml:"server" json:"server"`
+	CORS    api.CORSConfig `yaml:"cors" json:"cors"`
+	Mongo   db.Config      `yaml:"mongo" json:"mongo"`
+	Redis   cache.Config   `yaml:"redis" json:"redis"`
+	Auth    auth.Config    `yaml:"auth" json:"auth"`
+	Notify  notify.Config  `yaml:"notify" json:"notify"`
+	Storage storage.Config `yaml:"storage" json:"storage"`
+	Photos  photos.Config  `yaml:"photos" json:"photos"`
+	Account account.Config `yaml:"account" json:"account"`
+
+	RateLimit ratelimit.Config `yaml:"rateLimit" json:"rateLimit"`
+}
+
+// providerName is the format of OIDC provider names, which clients send as signupMethod
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Default returns the configuration used when nothing overrides a field
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+			DrainDelay:        5 * time.Second,
+		},
+		CORS:  api.CORSConfig{AllowCredentials: true, MaxAge: 10 * time.Minute},
+		Mongo: db.Config{Database: db.DatabaseName, OperationTimeout: 5 * time.Second},
+		Redis: cache.Config{Addr: "localhost:6379"},
+		Auth: auth.Config{
+			GoogleCertsURL:   auth.GoogleCertsURL,
+			AppleKeysURL:     auth.AppleKeysURL,
+			FacebookGraphURL: auth.FacebookGraphURL,
+			MFAIssuer:        "AstroMatch",
+		},
+		Notify: notify.Config{
+			EmailProvider:  "smtp",
+			SMSProvider:    "brevo",
+			SMTPHost:       "smtp-relay.sendinblue.com",
+			SMTPPort:       587,
+			BrevoSMSSender: "AstroMatch",
+		},
+		Storage: storage.Config{Provider: "local", LocalDir: "data/blobs", PublicURL: "/media"},
+		Photos:  photos.Config{MaxPhotos: 6, MaxUploadSize: 10 << 20},
+		Account: account.Config{
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			PurgeInterval:       time.Hour,
+			ExportTTL:           48 * time.Hour,
+		},
+		RateLimit: ratelimit.Config{
+			Enabled:          true,
+			IPLimit:          30,
+			IPWindow:         time.Minute,
+			IdentifierLimit:  10,
+			IdentifierWindow: 15 * time.Minute,
+			Routes: map[string]ratelimit.RouteLimits{
+				// Every signup attempt can send an email or SMS
+				ratelimit.RouteSignup:             {PerIdentifier: ratelimit.Rule{Limit: 3, Window: time.Hour}},
+				ratelimit.RouteResendVerification: {PerIdentifier: ratelimit.Rule{Limit: 3, Window: time.Hour}},
+				// Five guesses per quarter hour make a six digit code unguessable
+				ratelimit.RouteVerifyOTP: {PerIdentifier: ratelimit.Rule{Limit: 5}},
+				ratelimit.RouteMFA:       {PerIdentifier: ratelimit.Rule{Limit: 5}},
+			},
+			LockoutThreshold:   5,
+			LockoutWindow:      24 * time.Hour,
+			LockoutDuration:    time.Minute,
+			LockoutMaxDuration: time.Hour,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the optional YAML/JSON file at path,
+// then environment variables. Any variable can instead be read from a file named by
+// the same variable with a _FILE suffix, e.g. JWT_SECRET_FILE=/run/secrets/jwt.
+func Load(path string) (Config, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Read is Load without validation, for tools that only need part of the configuration
+func Read(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	// Pages on other sites only send the session cookie when it is SameSite=None
+	if cfg.Auth.CookieSameSite == "" && cfg.CORS.AllowCredentials && len(cfg.CORS.AllowedOrigins) > 0 {
+		cfg.Auth.CookieSameSite = "none"
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a YAML or JSON config file into cfg
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: reading %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if !json.Valid(data) {
+			return fmt.Errorf("config: parsing %s: invalid JSON", path)
+		}
+		// JSON is YAML, and the YAML decoder reads durations such as "15s"
+		err = yaml.Unmarshal(data, cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("config: unsupported file type %q (use .yaml, .yml or .json)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every missing or invalid required setting at once
+func (c Config) Validate() error {
+	var problems []string
+	require := func(value, name string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, name+" is required")
+		}
+	}
+
+	require(c.Server.Addr, "HTTP_ADDR")
+	if c.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "HTTP_SHUTDOWN_TIMEOUT must be positive")
+	}
+	if c.Server.DrainDelay < 0 {
+		problems = append(problems, "HTTP_DRAIN_DELAY cannot be negative")
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if err := api.CheckOrigin(origin); err != nil {
+			problems = append(problems, "CORS_ALLOWED_ORIGINS: "+err.Error())
+		}
+		if origin == "*" && c.CORS.AllowCredentials {
+			problems = append(problems, "CORS_ALLOWED_ORIGINS cannot be * while CORS_ALLOW_CREDENTIALS is on")
+		}
+	}
+	require(c.Mongo.URI, "MONGO_URI")
+	require(c.Mongo.Database, "MONGO_DATABASE")
+	require(c.Redis.Addr, "REDIS_ADDR")
+	require(c.Auth.JWTSecret, "JWT_SECRET")
+	if len(c.Auth.GoogleClientIDs) == 0 {
+		problems = append(problems, "GOOGLE_CLIENT_IDS is required")
+	}
+	if _, err := auth.ParseSameSite(c.Auth.CookieSameSite); err != nil {
+		problems = append(problems, "SESSION_COOKIE_SAMESITE "+err.Error())
+	}
+	require(c.Auth.MFAEncryptionKey, "MFA_ENCRYPTION_KEY")
+	if c.Auth.MFAEncryptionKey != "" {
+		if _, err := auth.DecodeMFAKey(c.Auth.MFAEncryptionKey); err != nil {
+			problems = append(problems, "MFA_ENCRYPTION_KEY must be 32 bytes, base64 encoded: "+err.Error())
+		}
+	}
+	if c.Auth.WebAuthnRPID != "" && len(c.Auth.WebAuthnOrigins) == 0 {
+		problems = append(problems, "WEBAUTHN_ORIGINS is required when WEBAUTHN_RP_ID is set")
+	}
+	if c.Auth.FacebookAppID != "" {
+		require(c.Auth.FacebookAppSecret, "FACEBOOK_APP_SECRET")
+	}
+	providerNames := map[string]bool{
+		db.ProviderEmail: true, db.ProviderPhone: true, db.ProviderGoogle: true,
+		db.ProviderFacebook: true, db.ProviderApple: true,
+	}
+	for i, p := range c.Auth.OIDCProviders {
+		field := fmt.Sprintf("auth.oidcProviders[%d]", i)
+		switch {
+		case !providerName.MatchString(p.Name):
+			problems = append(problems, field+".name must be lowercase letters, digits and dashes")
+		case providerNames[p.Name]:
+			problems = append(problems, fmt.Sprintf("%s.name %q is already taken", field, p.Name))
+		}
+		providerNames[p.Name] = true
+		if u, err := url.Parse(p.Issuer); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			problems = append(problems, field+".issuer must be an absolute URL")
+		}
+		if len(p.ClientIDs) == 0 {
+			problems = append(problems, field+".clientIds is required")
+		}
+	}
+
+	switch c.Notify.EmailProvider {
+	case "smtp":
+		require(c.Notify.SMTPHost, "SMTP_HOST")
+		require(c.Notify.SMTPUsername, "SMTP_USERNAME")
+		require(c.Notify.SMTPPassword, "SMTP_PASSWORD")
+		require(c.Notify.From, "EMAIL_FROM")
+	case "brevo":
+		require(c.Notify.BrevoAPIKey, "BREVO_API_KEY")
+		require(c.Notify.From, "EMAIL_FROM")
+	case "outbox":
+	default:
+		problems = append(problems, fmt.Sprintf("EMAIL_PROVIDER %q is not one of smtp, brevo, outbox", c.Notify.EmailProvider))
+	}
+
+	switch c.Notify.SMSProvider {
+	case "brevo":
+		require(c.Notify.BrevoAPIKey, "BREVO_API_KEY")
+	case "outbox":
+	default:
+		problems = append(problems, fmt.Sprintf("SMS_PROVIDER %q is not one of brevo, outbox", c.Notify.SMSProvider))
+	}
+
+	switch c.Storage.Provider {
+	case "local":
+		require(c.Storage.LocalDir, "STORAGE_LOCAL_DIR")
+	case "s3":
+		require(c.Storage.S3Endpoint, "S3_ENDPOINT")
+		require(c.Storage.S3Bucket, "S3_BUCKET")
+		require(c.Storage.S3AccessKey, "S3_ACCESS_KEY_ID")
+		require(c.Storage.S3SecretKey, "S3_SECRET_ACCESS_KEY")
+	default:
+		problems = append(problems, fmt.Sprintf("STORAGE_PROVIDER %q is not one of local, s3", c.Storage.Provider))
+	}
+	if c.Photos.MaxPhotos <= 0 {
+		problems = append(problems, "PHOTOS_MAX_COUNT must be positive")
+	}
+	if c.Photos.MaxUploadSize <= 0 {
+		problems = append(problems, "PHOTOS_MAX_UPLOAD_BYTES must be positive")
+	}
+
+	if c.Account.DeletionGracePeriod < 0 {
+		problems = append(problems, "ACCOUNT_DELETION_GRACE_PERIOD must not be negative")
+	}
+	if c.Account.PurgeInterval <= 0 {
+		problems = append(problems, "ACCOUNT_PURGE_INTERVAL must be positive")
+	}
+	if c.Account.ExportTTL <= 0 {
+		problems = append(problems, "ACCOUNT_EXPORT_TTL must be positive")
+	}
+
+	if c.RateLimit.Enabled {
+		if c.RateLimit.IPLimit <= 0 || c.RateLimit.IPWindow <= 0 {
+			problems = append(problems, "RATE_LIMIT_IP_LIMIT and RATE_LIMIT_IP_WINDOW must be positive")
+		}
+		if c.RateLimit.IdentifierLimit <= 0 || c.RateLimit.IdentifierWindow <= 0 {
+			problems = append(problems, "RATE_LIMIT_IDENTIFIER_LIMIT and RATE_LIMIT_IDENTIFIER_WINDOW must be positive")
+		}
+		if c.RateLimit.LockoutThreshold < 0 {
+			problems = append(problems, "LOCKOUT_THRESHOLD must not be negative")
+		}
+		if c.RateLimit.LockoutThreshold > 0 {
+			if c.RateLimit.LockoutDuration <= 0 {
+				problems = append(problems, "LOCKOUT_DURATION must be positive")
+			}
+			// Failures are forgotten after the window, which would end a longer lockout early
+			if c.RateLimit.LockoutMaxDuration < c.RateLimit.LockoutDuration || c.RateLimit.LockoutMaxDuration > c.RateLimit.LockoutWindow {
+				problems = append(problems, "LOCKOUT_MAX_DURATION must be between LOCKOUT_DURATION and LOCKOUT_WINDOW")
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("config: " + strings.Join(dedupe(problems), "; "))
+	}
+	return nil
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadFileReadsDurations(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{"server": {"addr": ":9000", "shutdownTimeout": "15s"}, "cors": {"maxAge": "10m"}}`,
+		"config.yaml": "server:\n  addr: \":9000\"\n  shutdownTimeout: 15s\ncors:\n  maxAge: 10m\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg := Default()
+		if err := loadFile(path, &cfg); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.Server.Addr != ":9000" || cfg.Server.ShutdownTimeout != 15*time.Second || cfg.CORS.MaxAge != 10*time.Minute {
+			t.Errorf("%s: got addr %q, shutdown %s, max age %s", name, cfg.Server.Addr, cfg.Server.ShutdownTimeout, cfg.CORS.MaxAge)
+		}
+	}
+}
+
+func TestLoadFileRejectsYAMLInJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("server:\n  addr: \":9000\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Default()
+	if err := loadFile(path, &cfg); err == nil {
+		t.Error("a .json file holding YAML was accepted")
+	}
+}
+
+func TestReadDefaultsSessionCookieForCORS(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"same origin", nil, ""},
+		{"credentialed origins", map[string]string{"CORS_ALLOWED_ORIGINS": "https://astromatch.app"}, "none"},
+		{"origins without credentials", map[string]string{"CORS_ALLOWED_ORIGINS": "https://astromatch.app", "CORS_ALLOW_CREDENTIALS": "false"}, ""},
+		{"explicit setting", map[string]string{"CORS_ALLOWED_ORIGINS": "https://astromatch.app", "SESSION_COOKIE_SAMESITE": "lax"}, "lax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CORS_ALLOWED_ORIGINS", "CORS_ALLOW_CREDENTIALS", "SESSION_COOKIE_SAMESITE"} {
+				// Setenv restores the variable after the test, so it can then be unset
+				t.Setenv(name, tt.env[name])
+				if _, ok := tt.env[name]; !ok {
+					os.Unsetenv(name)
+				}
+			}
+			cfg, err := Read("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Auth.CookieSameSite != tt.want {
+				t.Errorf("cookieSameSite = %q, want %q", cfg.Auth.CookieSameSite, tt.want)
+			}
+		})
+	}
+}
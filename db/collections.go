@@ -0,0 +1,47 @@
+package db
+
+// DatabaseName is the canonical database every collection lives in
+const DatabaseName = "astromatch"
+
+// LegacyDatabaseName is the differently-cased database older code wrote users to
+const LegacyDatabaseName = "astroMatch"
+
+// Collection names
+const (
+	UsersCollection       = "users"
+	PreferencesCollection = "preferences"
+	OTPCollection         = "user_otp"
+	MigrationsCollection  = "migrations"
+	ExportsCollection     = "user_exports"
+	IdentitiesCollection  = "identities"
+	PasskeysCollection    = "passkeys"
+)
+
+// Canonical document field names used in queries
+const (
+	FieldID            = "id"
+	FieldEmail         = "email"
+	FieldPhone         = "phone"
+	FieldIsVerified    = "is_verified"
+	FieldZodiacSign    = "zodiac_sign"
+	FieldUserID        = "user_id"
+	FieldPreferredSign = "preferred_sign"
+	FieldIdentifier    = "identifier"
+	FieldExpiresAt     = "expires_at"
+	FieldDeletedAt     = "deleted_at"
+	FieldStatus        = "status"
+	FieldCreatedAt     = "created_at"
+	FieldStartedAt     = "started_at"
+	FieldProvider      = "provider"
+	FieldSubject       = "subject"
+	FieldVerified      = "verified"
+	FieldLinkedAt      = "linked_at"
+	FieldMFA           = "mfa"
+	FieldCredentialID  = "credential_id"
+	FieldSignCount     = "sign_count"
+	FieldBackupState   = "backup_state"
+	FieldLastUsedAt    = "last_used_at"
+	FieldPassword      = "password"
+	FieldPhotos        = "photos"
+	FieldProfilePic    = "profile_pic"
+)
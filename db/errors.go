@@ -0,0 +1,70 @@
+package db
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error kinds. Repository errors wrap one of these so callers can use errors.Is
+// without knowing which resource failed.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrPrecondition = errors.New("precondition failed")
+)
+
+// kindError is an error with its own message that unwraps to a kind
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// NotFound returns an error wrapping ErrNotFound
+func NotFound(msg string) error {
+	return &kindError{msg: msg, kind: ErrNotFound}
+}
+
+// Conflict returns an error wrapping ErrConflict
+func Conflict(msg string) error {
+	return &kindError{msg: msg, kind: ErrConflict}
+}
+
+// PreconditionFailed returns an error wrapping ErrPrecondition
+func PreconditionFailed(msg string) error {
+	return &kindError{msg: msg, kind: ErrPrecondition}
+}
+
+// FieldError describes a problem with a single input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects field-level problems with an input
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Add records a problem with field
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns e when any field failed, otherwise nil
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
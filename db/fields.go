@@ -0,0 +1,51 @@
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FieldVersion is the document field holding User.Version
+const FieldVersion = "version"
+
+// ApplyFields returns user with the given document fields set, or removed when the
+// value is nil, using the same bson mapping MongoDB applies to $set and $unset
+func ApplyFields(user User, fields map[string]interface{}) (User, error) {
+	raw, err := bson.Marshal(user)
+	if err != nil {
+		return User{}, err
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return User{}, err
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(doc, name)
+		} else {
+			doc[name] = value
+		}
+	}
+
+	raw, err = bson.Marshal(doc)
+	if err != nil {
+		return User{}, err
+	}
+
+	var updated User
+	err = bson.Unmarshal(raw, &updated)
+	return updated, err
+}
+
+// splitFields separates fields into $set and $unset documents
+func splitFields(fields map[string]interface{}) (set, unset bson.M) {
+	set, unset = bson.M{}, bson.M{}
+	for name, value := range fields {
+		if value == nil {
+			unset[name] = ""
+		} else {
+			set[name] = value
+		}
+	}
+	return set, unset
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexSpec declares an index the application relies on
+type IndexSpec struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	// Partial limits the index to documents where the keyed field is a non-empty string,
+	// so users without an email or phone do not collide on ""
+	Partial bson.M
+	// TTL expires documents once the indexed date field is older than this
+	TTL *time.Duration
+}
+
+// expireAtDate is a zero TTL: documents expire exactly at their stored date
+var expireAtDate time.Duration
+
+// Indexes is the declarative list of indexes applied at startup
+var Indexes = []IndexSpec{
+	{Collection: UsersCollection, Name: "users_id_unique", Keys: bson.D{{Key: FieldID, Value: 1}}, Unique: true},
+	{Collection: UsersCollection, Name: "users_email_unique", Keys: bson.D{{Key: FieldEmail, Value: 1}}, Unique: true,
+		Partial: bson.M{FieldEmail: bson.M{"$gt": ""}}},
+	{Collection: UsersCollection, Name: "users_phone_unique", Keys: bson.D{{Key: FieldPhone, Value: 1}}, Unique: true,
+		Partial: bson.M{FieldPhone: bson.M{"$gt": ""}}},
+	// UserRepository.ListCandidates finds matchmaking candidates by verification and sign
+	{Collection: UsersCollection, Name: "users_verified_sign", Keys: bson.D{{Key: FieldIsVerified, Value: 1}, {Key: FieldZodiacSign, Value: 1}}},
+
+	// The purge worker looks for accounts whose grace period has passed
+	{Collection: UsersCollection, Name: "users_deleted_at", Keys: bson.D{{Key: FieldDeletedAt, Value: 1}},
+		Partial: bson.M{FieldDeletedAt: bson.M{"$exists": true}}},
+
+	{Collection: OTPCollection, Name: "otp_identifier", Keys: bson.D{{Key: FieldIdentifier, Value: 1}}},
+	{Collection: OTPCollection, Name: "otp_expires_ttl", Keys: bson.D{{Key: FieldExpiresAt, Value: 1}}, TTL: &expireAtDate},
+
+	{Collection: PreferencesCollection, Name: "preferences_user_unique", Keys: bson.D{{Key: FieldUserID, Value: 1}}, Unique: true},
+	{Collection: PreferencesCollection, Name: "preferences_sign", Keys: bson.D{{Key: FieldPreferredSign, Value: 1}}},
+
+	{Collection: ExportsCollection, Name: "exports_id_unique", Keys: bson.D{{Key: FieldID, Value: 1}}, Unique: true},
+	{Collection: ExportsCollection, Name: "exports_user_created", Keys: bson.D{{Key: FieldUserID, Value: 1}, {Key: FieldCreatedAt, Value: -1}}},
+	{Collection: ExportsCollection, Name: "exports_status_created", Keys: bson.D{{Key: FieldStatus, Value: 1}, {Key: FieldCreatedAt, Value: 1}}},
+	{Collection: ExportsCollection, Name: "exports_expires_at", Keys: bson.D{{Key: FieldExpiresAt, Value: 1}}},
+
+	// One account per provider login; sign-in looks identities up by provider and subject
+	{Collection: IdentitiesCollection, Name: "identities_provider_subject_unique",
+		Keys: bson.D{{Key: FieldProvider, Value: 1}, {Key: FieldSubject, Value: 1}}, Unique: true},
+	{Collection: IdentitiesCollection, Name: "identities_user", Keys: bson.D{{Key: FieldUserID, Value: 1}}},
+	// Auto-linking finds the account that owns a verified email
+	{Collection: IdentitiesCollection, Name: "identities_email_verified",
+		Keys: bson.D{{Key: FieldEmail, Value: 1}, {Key: FieldVerified, Value: 1}}},
+
+	// Login looks passkeys up by credential ID, which authenticators make unique
+	{Collection: PasskeysCollection, Name: "passkeys_credential_id_unique",
+		Keys: bson.D{{Key: FieldCredentialID, Value: 1}}, Unique: true},
+	{Collection: PasskeysCollection, Name: "passkeys_user", Keys: bson.D{{Key: FieldUserID, Value: 1}}},
+
+	{Collection: MigrationsCollection, Name: "migrations_version_unique", Keys: bson.D{{Key: "version", Value: 1}}, Unique: true},
+}
+
+// model converts the spec into a driver index model
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Partial != nil {
+		opts.SetPartialFilterExpression(s.Partial)
+	}
+	if s.TTL != nil {
+		opts.SetExpireAfterSeconds(int32(s.TTL.Seconds()))
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// EnsureIndexes creates every declared index that does not exist yet
+func EnsureIndexes(ctx context.Context, database *mongo.Database) error {
+	byCollection := make(map[string][]mongo.IndexModel)
+	for _, spec := range Indexes {
+		byCollection[spec.Collection] = append(byCollection[spec.Collection], spec.model())
+	}
+
+	for collection, models := range byCollection {
+		if _, err := database.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// IndexDrift describes differences between declared and existing indexes
+type IndexDrift struct {
+	Missing    []string // declared but not present
+	Mismatched []string // present under the declared name with different options
+	Undeclared []string // present but not declared (excluding _id_)
+}
+
+// Empty reports whether declared and existing indexes agree
+func (d IndexDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Mismatched) == 0 && len(d.Undeclared) == 0
+}
+
+func (d IndexDrift) String() string {
+	if d.Empty() {
+		return "no index drift"
+	}
+	var parts []string
+	if len(d.Missing) > 0 {
+		parts = append(parts, "missing: "+strings.Join(d.Missing, ", "))
+	}
+	if len(d.Mismatched) > 0 {
+		parts = append(parts, "mismatched: "+strings.Join(d.Mismatched, ", "))
+	}
+	if len(d.Undeclared) > 0 {
+		parts = append(parts, "undeclared: "+strings.Join(d.Undeclared, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// existingIndex is the subset of listIndexes output compared against specs
+type existingIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	PartialFilter      bson.M `bson:"partialFilterExpression"`
+	ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+}
+
+// CheckIndexes compares declared indexes with those present in the database
+func CheckIndexes(ctx context.Context, database *mongo.Database) (IndexDrift, error) {
+	var drift IndexDrift
+
+	declared := make(map[string]map[string]IndexSpec)
+	for _, spec := range Indexes {
+		if declared[spec.Collection] == nil {
+			declared[spec.Collection] = make(map[string]IndexSpec)
+		}
+		declared[spec.Collection][spec.Name] = spec
+	}
+
+	collections := make([]string, 0, len(declared))
+	for c := range declared {
+		collections = append(collections, c)
+	}
+	sort.Strings(collections)
+
+	for _, collection := range collections {
+		cursor, err := database.Collection(collection).Indexes().List(ctx)
+		if err != nil {
+			return drift, err
+		}
+		var existing []existingIndex
+		if err := cursor.All(ctx, &existing); err != nil {
+			return drift, err
+		}
+
+		seen := make(map[string]bool)
+		for _, idx := range existing {
+			if idx.Name == "_id_" {
+				continue
+			}
+			qualified := collection + "." + idx.Name
+			spec, ok := declared[collection][idx.Name]
+			if !ok {
+				drift.Undeclared = append(drift.Undeclared, qualified)
+				continue
+			}
+			seen[idx.Name] = true
+			if !spec.matches(idx) {
+				drift.Mismatched = append(drift.Mismatched, qualified)
+			}
+		}
+
+		for name := range declared[collection] {
+			if !seen[name] {
+				drift.Missing = append(drift.Missing, collection+"."+name)
+			}
+		}
+	}
+
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Mismatched)
+	sort.Strings(drift.Undeclared)
+	return drift, nil
+}
+
+func (s IndexSpec) matches(idx existingIndex) bool {
+	if s.Unique != idx.Unique || len(s.Keys) != len(idx.Key) {
+		return false
+	}
+	for i, k := range s.Keys {
+		if idx.Key[i].Key != k.Key || fmt.Sprint(idx.Key[i].Value) != fmt.Sprint(k.Value) {
+			return false
+		}
+	}
+	if (s.TTL == nil) != (idx.ExpireAfterSeconds == nil) {
+		return false
+	}
+	if s.TTL != nil && int32(s.TTL.Seconds()) != *idx.ExpireAfterSeconds {
+		return false
+	}
+	if (s.Partial == nil) != (idx.PartialFilter == nil) {
+		return false
+	}
+	if s.Partial != nil {
+		want, _ := bson.Marshal(s.Partial)
+		got, _ := bson.Marshal(idx.PartialFilter)
+		var wantDoc, gotDoc bson.M
+		bson.Unmarshal(want, &wantDoc)
+		bson.Unmarshal(got, &gotDoc)
+		if !reflect.DeepEqual(wantDoc, gotDoc) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyIndexes ensures declared indexes exist and logs any remaining drift. It
+// fails when a unique index is missing or differs, because signup relies on
+// them alone to reject duplicate accounts; other drift is only logged.
+func ApplyIndexes(ctx context.Context, database *mongo.Database) error {
+	// Building one index can fail, e.g. on duplicate legacy data, while the rest
+	// exist; CheckIndexes then tells which are missing
+	if err := EnsureIndexes(ctx, database); err != nil {
+		log.Printf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	drift, err := CheckIndexes(ctx, database)
+	if err != nil {
+		return err
+	}
+	if drift.Empty() {
+		log.Println("MongoDB indexes up to date")
+		return nil
+	}
+	log.Printf("MongoDB index drift: %s", drift)
+	if unique := drift.unique(); len(unique) > 0 {
+		return fmt.Errorf("unique indexes missing or different: %s (run the migrations, which report duplicate data)", strings.Join(unique, ", "))
+	}
+	return nil
+}
+
+// unique lists the missing and mismatched indexes that are declared unique
+func (d IndexDrift) unique() []string {
+	isUnique := make(map[string]bool)
+	for _, spec := range Indexes {
+		isUnique[spec.Collection+"."+spec.Name] = spec.Unique
+	}
+	var names []string
+	for _, name := range append(append([]string(nil), d.Missing...), d.Mismatched...) {
+		if isUnique[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,608 @@
+// Package memory provides in-memory repositories for running the API without MongoDB
+package memory
+
+import (
+	"astromatch/db"
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewStore builds an empty in-memory store
+func NewStore() *db.Store {
+	return &db.Store{
+		Users:       NewUserRepository(),
+		Preferences: NewPreferencesRepository(),
+		OTPs:        NewOTPRepository(),
+		Exports:     NewExportRepository(),
+		Identities:  NewIdentityRepository(),
+		Passkeys:    NewPasskeyRepository(),
+	}
+}
+
+// UserRepository is an in-memory db.UserRepository
+type UserRepository struct {
+	mu    sync.RWMutex
+	users map[string]db.User
+}
+
+// NewUserRepository creates an empty user repository
+func NewUserRepository() *UserRepository {
+	return &UserRepository{users: make(map[string]db.User)}
+}
+
+// GetByID fetches a user by their ID
+func (r *UserRepository) GetByID(ctx context.Context, id string) (db.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return db.User{}, db.ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetByEmail fetches a user by their email
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (db.User, error) {
+	if email == "" {
+		return db.User{}, db.ErrUserNotFound
+	}
+	return r.find(func(u db.User) bool { return u.Email == email })
+}
+
+// GetByPhone fetches a user by their phone number
+func (r *UserRepository) GetByPhone(ctx context.Context, phone string) (db.User, error) {
+	if phone == "" {
+		return db.User{}, db.ErrUserNotFound
+	}
+	return r.find(func(u db.User) bool { return u.Phone == phone })
+}
+
+func (r *UserRepository) find(match func(db.User) bool) (db.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return db.User{}, db.ErrUserNotFound
+}
+
+// ListCandidates retrieves verified users with zodiacSign that are not awaiting
+// deletion, ordered by ID
+func (r *UserRepository) ListCandidates(ctx context.Context, zodiacSign string) ([]db.User, error) {
+	if zodiacSign == "" {
+		return nil, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []db.User
+	for _, user := range r.users {
+		if user.IsVerified && user.ZodiacSign == zodiacSign && user.DeletedAt == nil {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// Create inserts a new user
+func (r *UserRepository) Create(ctx context.Context, user db.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+
+	// Mirror the unique indexes on id, email and phone
+	for _, existing := range r.users {
+		if existing.ID == user.ID ||
+			(user.Email != "" && existing.Email == user.Email) ||
+			(user.Phone != "" && existing.Phone == user.Phone) {
+			return db.ErrDuplicateUser
+		}
+	}
+	r.users[user.ID] = user
+	return nil
+}
+
+// UpdateFields applies a partial update guarded by the user's version
+func (r *UserRepository) UpdateFields(ctx context.Context, id string, fields map[string]interface{}, expectedVersion int64) (db.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[id]
+	if !ok {
+		return db.User{}, db.ErrUserNotFound
+	}
+	if expectedVersion != db.AnyVersion && existing.Version != expectedVersion {
+		return db.User{}, db.ErrVersionMismatch
+	}
+
+	updated, err := db.ApplyFields(existing, fields)
+	if err != nil {
+		return db.User{}, err
+	}
+	updated.ID = id
+	updated.Version = existing.Version + 1
+
+	// Mirror the unique indexes on email and phone, as Create does
+	for otherID, other := range r.users {
+		if otherID != id &&
+			((updated.Email != "" && other.Email == updated.Email) ||
+				(updated.Phone != "" && other.Phone == updated.Phone)) {
+			return db.User{}, db.ErrDuplicateUser
+		}
+	}
+	r.users[id] = updated
+	return updated, nil
+}
+
+// SetVerified marks a user as verified
+func (r *UserRepository) SetVerified(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return db.ErrUserNotFound
+	}
+	user.IsVerified = true
+	r.users[id] = user
+	return nil
+}
+
+// ListDeletedBefore retrieves users whose deletion was requested before cutoff
+func (r *UserRepository) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]db.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []db.User
+	for _, user := range r.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(cutoff) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// DeleteIfDeletedBefore removes a user whose deletion was requested before cutoff
+func (r *UserRepository) DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.DeletedAt == nil || !user.DeletedAt.Before(cutoff) {
+		return db.ErrUserNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+// PreferencesRepository is an in-memory db.PreferencesRepository
+type PreferencesRepository struct {
+	mu    sync.RWMutex
+	prefs map[string]db.UserPreferences
+}
+
+// NewPreferencesRepository creates an empty preferences repository
+func NewPreferencesRepository() *PreferencesRepository {
+	return &PreferencesRepository{prefs: make(map[string]db.UserPreferences)}
+}
+
+// Get fetches preferences for a user
+func (r *PreferencesRepository) Get(ctx context.Context, userID string) (db.UserPreferences, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	prefs, ok := r.prefs[userID]
+	if !ok {
+		return db.UserPreferences{}, db.ErrPreferencesNotFound
+	}
+	return prefs, nil
+}
+
+// GetMany fetches preferences for several users
+func (r *PreferencesRepository) GetMany(ctx context.Context, userIDs []string) (map[string]db.UserPreferences, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byUser := make(map[string]db.UserPreferences)
+	for _, id := range userIDs {
+		if prefs, ok := r.prefs[id]; ok {
+			byUser[id] = prefs
+		}
+	}
+	return byUser, nil
+}
+
+// Upsert stores preferences for a user
+func (r *PreferencesRepository) Upsert(ctx context.Context, userID string, prefs db.UserPreferences) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prefs.UserID = userID
+	r.prefs[userID] = prefs
+	return nil
+}
+
+// Delete removes a user's preferences
+func (r *PreferencesRepository) Delete(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.prefs, userID)
+	return nil
+}
+
+// OTPRepository is an in-memory db.OTPRepository
+type OTPRepository struct {
+	mu   sync.Mutex
+	otps []db.UserOTP
+}
+
+// NewOTPRepository creates an empty OTP repository
+func NewOTPRepository() *OTPRepository {
+	return &OTPRepository{}
+}
+
+// Save stores an OTP
+func (r *OTPRepository) Save(ctx context.Context, otp db.UserOTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.otps = append(r.otps, otp)
+	return nil
+}
+
+// ListValid returns the unexpired OTPs for identifier
+func (r *OTPRepository) ListValid(ctx context.Context, identifier string, now time.Time) ([]db.UserOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var otps []db.UserOTP
+	for _, stored := range r.otps {
+		if stored.Identifier == identifier && stored.ExpiresAt.After(now) {
+			otps = append(otps, stored)
+		}
+	}
+	return otps, nil
+}
+
+// DeleteByIdentifier removes every OTP issued to identifier
+func (r *OTPRepository) DeleteByIdentifier(ctx context.Context, identifier string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.otps[:0]
+	for _, stored := range r.otps {
+		if stored.Identifier != identifier {
+			kept = append(kept, stored)
+		}
+	}
+	r.otps = kept
+	return nil
+}
+
+// ExportRepository is an in-memory db.ExportRepository
+type ExportRepository struct {
+	mu   sync.Mutex
+	jobs []db.ExportJob
+}
+
+// NewExportRepository creates an empty export repository
+func NewExportRepository() *ExportRepository {
+	return &ExportRepository{}
+}
+
+// Create stores a new export job
+func (r *ExportRepository) Create(ctx context.Context, job db.ExportJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs = append(r.jobs, job)
+	return nil
+}
+
+// Latest fetches the user's newest export job
+func (r *ExportRepository) Latest(ctx context.Context, userID string) (db.ExportJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var latest *db.ExportJob
+	for i, job := range r.jobs {
+		if job.UserID == userID && (latest == nil || job.CreatedAt.After(latest.CreatedAt)) {
+			latest = &r.jobs[i]
+		}
+	}
+	if latest == nil {
+		return db.ExportJob{}, db.ErrExportNotFound
+	}
+	return *latest, nil
+}
+
+// ClaimPending moves the oldest claimable job to running
+func (r *ExportRepository) ClaimPending(ctx context.Context, now, staleBefore time.Time) (db.ExportJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	claim := -1
+	for i, job := range r.jobs {
+		claimable := job.Status == db.ExportPending ||
+			(job.Status == db.ExportRunning && job.StartedAt != nil && job.StartedAt.Before(staleBefore))
+		if claimable && (claim < 0 || job.CreatedAt.Before(r.jobs[claim].CreatedAt)) {
+			claim = i
+		}
+	}
+	if claim < 0 {
+		return db.ExportJob{}, db.ErrExportNotFound
+	}
+	r.jobs[claim].Status = db.ExportRunning
+	r.jobs[claim].StartedAt = &now
+	return r.jobs[claim], nil
+}
+
+// Save replaces an export job
+func (r *ExportRepository) Save(ctx context.Context, job db.ExportJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.jobs {
+		if r.jobs[i].ID == job.ID {
+			r.jobs[i] = job
+			return nil
+		}
+	}
+	return db.ErrExportNotFound
+}
+
+// ListByUser fetches every export job of a user
+func (r *ExportRepository) ListByUser(ctx context.Context, userID string) ([]db.ExportJob, error) {
+	return r.filter(func(job db.ExportJob) bool { return job.UserID == userID }), nil
+}
+
+// ListExpired fetches export jobs past their expiry
+func (r *ExportRepository) ListExpired(ctx context.Context, now time.Time) ([]db.ExportJob, error) {
+	return r.filter(func(job db.ExportJob) bool { return job.ExpiresAt.Before(now) }), nil
+}
+
+func (r *ExportRepository) filter(match func(db.ExportJob) bool) []db.ExportJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var jobs []db.ExportJob
+	for _, job := range r.jobs {
+		if match(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Delete removes an export job
+func (r *ExportRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.jobs[:0]
+	for _, job := range r.jobs {
+		if job.ID != id {
+			kept = append(kept, job)
+		}
+	}
+	r.jobs = kept
+	return nil
+}
+
+// IdentityRepository is an in-memory db.IdentityRepository
+type IdentityRepository struct {
+	mu         sync.Mutex
+	identities []db.Identity
+}
+
+// NewIdentityRepository creates an empty identity repository
+func NewIdentityRepository() *IdentityRepository {
+	return &IdentityRepository{}
+}
+
+// Create links a new identity, enforcing the unique provider subject
+func (r *IdentityRepository) Create(ctx context.Context, identity db.Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return db.ErrDuplicateIdentity
+		}
+	}
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+// Find fetches the identity for a provider subject
+func (r *IdentityRepository) Find(ctx context.Context, provider, subject string) (db.Identity, error) {
+	return r.find(func(i db.Identity) bool { return i.Provider == provider && i.Subject == subject })
+}
+
+// FindVerifiedByEmail fetches an identity with a verified email
+func (r *IdentityRepository) FindVerifiedByEmail(ctx context.Context, email string) (db.Identity, error) {
+	return r.find(func(i db.Identity) bool { return i.Email == email && i.Verified })
+}
+
+func (r *IdentityRepository) find(match func(db.Identity) bool) (db.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, identity := range r.identities {
+		if match(identity) {
+			return identity, nil
+		}
+	}
+	return db.Identity{}, db.ErrIdentityNotFound
+}
+
+// ListByUser fetches every identity linked to a user, oldest first
+func (r *IdentityRepository) ListByUser(ctx context.Context, userID string) ([]db.Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var identities []db.Identity
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.SliceStable(identities, func(i, j int) bool { return identities[i].LinkedAt.Before(identities[j].LinkedAt) })
+	return identities, nil
+}
+
+// MarkVerified flags an identity as verified
+func (r *IdentityRepository) MarkVerified(ctx context.Context, provider, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.identities {
+		if r.identities[i].Provider == provider && r.identities[i].Subject == subject {
+			r.identities[i].Verified = true
+			return nil
+		}
+	}
+	return db.ErrIdentityNotFound
+}
+
+// Delete unlinks an identity
+func (r *IdentityRepository) Delete(ctx context.Context, provider, subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			r.identities = append(r.identities[:i], r.identities[i+1:]...)
+			return nil
+		}
+	}
+	return db.ErrIdentityNotFound
+}
+
+// DeleteByUser unlinks every identity of a user
+func (r *IdentityRepository) DeleteByUser(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.identities[:0]
+	for _, identity := range r.identities {
+		if identity.UserID != userID {
+			kept = append(kept, identity)
+		}
+	}
+	r.identities = kept
+	return nil
+}
+
+// PasskeyRepository is an in-memory db.PasskeyRepository
+type PasskeyRepository struct {
+	mu       sync.Mutex
+	passkeys []db.Passkey
+}
+
+// NewPasskeyRepository creates an empty passkey repository
+func NewPasskeyRepository() *PasskeyRepository {
+	return &PasskeyRepository{}
+}
+
+// Create stores a new passkey, enforcing the unique credential ID
+func (r *PasskeyRepository) Create(ctx context.Context, passkey db.Passkey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.passkeys {
+		if bytes.Equal(existing.CredentialID, passkey.CredentialID) {
+			return db.ErrDuplicatePasskey
+		}
+	}
+	r.passkeys = append(r.passkeys, passkey)
+	return nil
+}
+
+// FindByCredentialID fetches the passkey with a WebAuthn credential ID
+func (r *PasskeyRepository) FindByCredentialID(ctx context.Context, credentialID []byte) (db.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, passkey := range r.passkeys {
+		if bytes.Equal(passkey.CredentialID, credentialID) {
+			return passkey, nil
+		}
+	}
+	return db.Passkey{}, db.ErrPasskeyNotFound
+}
+
+// ListByUser fetches a user's passkeys, oldest first
+func (r *PasskeyRepository) ListByUser(ctx context.Context, userID string) ([]db.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var passkeys []db.Passkey
+	for _, passkey := range r.passkeys {
+		if passkey.UserID == userID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	sort.SliceStable(passkeys, func(i, j int) bool { return passkeys[i].CreatedAt.Before(passkeys[j].CreatedAt) })
+	return passkeys, nil
+}
+
+// RecordUse updates the counter and backup state after a login
+func (r *PasskeyRepository) RecordUse(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.passkeys {
+		if r.passkeys[i].ID == id {
+			r.passkeys[i].SignCount = signCount
+			r.passkeys[i].BackupState = backupState
+			r.passkeys[i].LastUsedAt = &usedAt
+			return nil
+		}
+	}
+	return db.ErrPasskeyNotFound
+}
+
+// Delete removes one of a user's passkeys
+func (r *PasskeyRepository) Delete(ctx context.Context, userID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, passkey := range r.passkeys {
+		if passkey.ID == id && passkey.UserID == userID {
+			r.passkeys = append(r.passkeys[:i], r.passkeys[i+1:]...)
+			return nil
+		}
+	}
+	return db.ErrPasskeyNotFound
+}
+
+// DeleteByUser removes every passkey of a user
+func (r *PasskeyRepository) DeleteByUser(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.passkeys[:0]
+	for _, passkey := range r.passkeys {
+		if passkey.UserID != userID {
+			kept = append(kept, passkey)
+		}
+	}
+	r.passkeys = kept
+	return nil
+}
@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"astromatch/db"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateFieldsKeepsEmailAndPhoneUnique(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository()
+	for _, u := range []db.User{
+		{ID: "a", Email: "a@example.com", Phone: "+15550000001"},
+		{ID: "b", Email: "b@example.com"},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   error
+	}{
+		{"another user's email", map[string]interface{}{db.FieldEmail: "a@example.com"}, db.ErrDuplicateUser},
+		{"another user's phone", map[string]interface{}{db.FieldPhone: "+15550000001"}, db.ErrDuplicateUser},
+		{"own email", map[string]interface{}{db.FieldEmail: "b@example.com"}, nil},
+		{"free phone", map[string]interface{}{db.FieldPhone: "+15550000002"}, nil},
+	}
+	for _, tt := range tests {
+		if _, err := users.UpdateFields(ctx, "b", tt.fields, db.AnyVersion); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	b, err := users.GetByID(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Email != "b@example.com" || b.Phone != "+15550000002" {
+		t.Errorf("rejected updates were stored: %+v", b)
+	}
+}
+
+func TestUpdateFieldsChecksVersion(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository()
+	if err := users.Create(ctx, db.User{ID: "a", Name: "Ada"}); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := users.UpdateFields(ctx, "a", map[string]interface{}{"name": "Ada L"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 1 || updated.Name != "Ada L" {
+		t.Fatalf("got %+v", updated)
+	}
+	if _, err := users.UpdateFields(ctx, "a", map[string]interface{}{"name": "Stale"}, 0); !errors.Is(err, db.ErrVersionMismatch) {
+		t.Errorf("stale version: got %v, want ErrVersionMismatch", err)
+	}
+	if _, err := users.UpdateFields(ctx, "missing", map[string]interface{}{"name": "X"}, db.AnyVersion); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("missing user: got %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestListCandidates(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository()
+	deleted := time.Now()
+	for _, u := range []db.User{
+		{ID: "c", ZodiacSign: "Leo", IsVerified: true},
+		{ID: "a", ZodiacSign: "Leo", IsVerified: true},
+		{ID: "unverified", ZodiacSign: "Leo"},
+		{ID: "deleted", ZodiacSign: "Leo", IsVerified: true, DeletedAt: &deleted},
+		{ID: "virgo", ZodiacSign: "Virgo", IsVerified: true},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	candidates, err := users.ListCandidates(ctx, "Leo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, u := range candidates {
+		ids = append(ids, u.ID)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "c" {
+		t.Errorf("got %v, want [a c]", ids)
+	}
+}
+
+func TestEmptyIdentifiersMatchNobody(t *testing.T) {
+	ctx := context.Background()
+	users := NewUserRepository()
+	for _, u := range []db.User{
+		{ID: "phone", Phone: "+15550000001", IsVerified: true},
+		{ID: "email", Email: "a@example.com", IsVerified: true},
+	} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if u, err := users.GetByEmail(ctx, ""); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetByEmail(\"\") = %q, %v", u.ID, err)
+	}
+	if u, err := users.GetByPhone(ctx, ""); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetByPhone(\"\") = %q, %v", u.ID, err)
+	}
+	if candidates, err := users.ListCandidates(ctx, ""); err != nil || len(candidates) != 0 {
+		t.Errorf("ListCandidates(\"\") = %v, %v", candidates, err)
+	}
+}
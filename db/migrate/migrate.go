@@ -0,0 +1,111 @@
+// Package migrate applies versioned, idempotent schema migrations to MongoDB
+package migrate
+
+import (
+	"astromatch/db"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a single schema change. Up must be safe to run more than once.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, client *mongo.Client, database *mongo.Database) error
+}
+
+// Record is the document stored in the migrations collection for each applied migration
+type Record struct {
+	Version   int       `bson:"version"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Status describes whether a migration has been applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Applied returns the records of migrations already applied to database
+func Applied(ctx context.Context, database *mongo.Database) (map[int]Record, error) {
+	cursor, err := database.Collection(db.MigrationsCollection).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]Record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// List reports every known migration and when it was applied
+func List(ctx context.Context, database *mongo.Database) ([]Status, error) {
+	applied, err := Applied(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, m := range sorted() {
+		s := Status{Migration: m}
+		if r, ok := applied[m.Version]; ok {
+			at := r.AppliedAt
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration in version order and records each one
+func Up(ctx context.Context, client *mongo.Client, database *mongo.Database) ([]Migration, error) {
+	applied, err := Applied(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	records := database.Collection(db.MigrationsCollection)
+	var ran []Migration
+	for _, m := range sorted() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		log.Printf("Applying migration %03d %s", m.Version, m.Name)
+		if err := m.Up(ctx, client, database); err != nil {
+			return ran, fmt.Errorf("migration %03d %s: %w", m.Version, m.Name, err)
+		}
+
+		_, err := records.UpdateOne(ctx,
+			bson.M{"version": m.Version},
+			bson.M{"$setOnInsert": Record{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return ran, fmt.Errorf("recording migration %03d: %w", m.Version, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+func sorted() []Migration {
+	ms := append([]Migration(nil), migrations...)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].Version < ms[j].Version })
+	return ms
+}
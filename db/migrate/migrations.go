@@ -0,0 +1,199 @@
+package migrate
+
+import (
+	"astromatch/db"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations is the ordered history of schema changes
+var migrations = []Migration{
+	{Version: 1, Name: "merge_legacy_users_database", Up: mergeLegacyUsers},
+	{Version: 2, Name: "normalize_verified_flag", Up: normalizeVerifiedFlag},
+	{Version: 3, Name: "backfill_identities", Up: backfillIdentities},
+	{Version: 4, Name: "check_duplicate_contacts", Up: checkDuplicateContacts},
+}
+
+// mergeLegacyUsers copies users that were written to the differently-cased legacy
+// database into the canonical one, keeping the canonical copy when both exist
+func mergeLegacyUsers(ctx context.Context, client *mongo.Client, database *mongo.Database) error {
+	if database.Name() == db.LegacyDatabaseName {
+		return nil
+	}
+
+	legacy := client.Database(db.LegacyDatabaseName).Collection(db.UsersCollection)
+	target := database.Collection(db.UsersCollection)
+
+	cursor, err := legacy.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	merged := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		delete(doc, "_id")
+
+		// Match on id when present, otherwise on whichever contact field exists
+		filter := bson.M{}
+		switch {
+		case doc[db.FieldID] != nil && doc[db.FieldID] != "":
+			filter[db.FieldID] = doc[db.FieldID]
+		case doc[db.FieldEmail] != nil && doc[db.FieldEmail] != "":
+			filter[db.FieldEmail] = doc[db.FieldEmail]
+		case doc[db.FieldPhone] != nil && doc[db.FieldPhone] != "":
+			filter[db.FieldPhone] = doc[db.FieldPhone]
+		default:
+			log.Printf("Skipping legacy user without id, email or phone")
+			continue
+		}
+
+		result, err := target.UpdateOne(ctx, filter, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		if result.UpsertedCount > 0 {
+			merged++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Merged %d users from %s.%s", merged, db.LegacyDatabaseName, db.UsersCollection)
+	return nil
+}
+
+// normalizeVerifiedFlag folds the isVerified and isverified variants into is_verified
+func normalizeVerifiedFlag(ctx context.Context, client *mongo.Client, database *mongo.Database) error {
+	users := database.Collection(db.UsersCollection)
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"isVerified": bson.M{"$exists": true}},
+		bson.M{"isverified": bson.M{"$exists": true}},
+		bson.M{db.FieldIsVerified: bson.M{"$exists": false}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			db.FieldIsVerified: bson.M{"$or": bson.A{
+				bson.M{"$eq": bson.A{"$" + db.FieldIsVerified, true}},
+				bson.M{"$eq": bson.A{"$isVerified", true}},
+				bson.M{"$eq": bson.A{"$isverified", true}},
+			}},
+		}}},
+		{{Key: "$unset", Value: bson.A{"isVerified", "isverified"}}},
+	}
+
+	result, err := users.UpdateMany(ctx, filter, pipeline)
+	if err != nil {
+		return err
+	}
+	log.Printf("Normalized verified flag on %d users", result.ModifiedCount)
+	return nil
+}
+
+// backfillIdentities creates email and phone identities for users that predate
+// account linking. Google and Facebook users are linked on their next sign-in,
+// since their provider subject was never stored.
+func backfillIdentities(ctx context.Context, client *mongo.Client, database *mongo.Database) error {
+	users := database.Collection(db.UsersCollection)
+	identities := database.Collection(db.IdentitiesCollection)
+
+	cursor, err := users.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	created := 0
+	for cursor.Next(ctx) {
+		var user db.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		var pending []db.Identity
+		if user.Email != "" && user.Password != "" {
+			pending = append(pending, db.Identity{
+				UserID: user.ID, Provider: db.ProviderEmail, Subject: user.Email, Email: user.Email,
+				Verified: user.IsVerified && user.SignupMethod == db.ProviderEmail,
+			})
+		}
+		if user.Phone != "" {
+			pending = append(pending, db.Identity{
+				UserID: user.ID, Provider: db.ProviderPhone, Subject: user.Phone,
+				Verified: user.IsVerified && user.SignupMethod == db.ProviderPhone,
+			})
+		}
+
+		for _, identity := range pending {
+			identity.LinkedAt = time.Now().UTC()
+			result, err := identities.UpdateOne(ctx,
+				bson.M{db.FieldProvider: identity.Provider, db.FieldSubject: identity.Subject},
+				bson.M{"$setOnInsert": identity},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil {
+				return err
+			}
+			if result.UpsertedCount > 0 {
+				created++
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Backfilled %d identities", created)
+	return nil
+}
+
+// checkDuplicateContacts reports users sharing an email or phone number, which
+// keep the unique indexes signup relies on from being built. Which account keeps
+// a contact is an operator's call, so the migration fails until each value
+// belongs to one user.
+func checkDuplicateContacts(ctx context.Context, client *mongo.Client, database *mongo.Database) error {
+	users := database.Collection(db.UsersCollection)
+
+	duplicates := 0
+	for _, field := range []string{db.FieldEmail, db.FieldPhone} {
+		// The same filter as the partial unique index
+		cursor, err := users.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{field: bson.M{"$gt": ""}}}},
+			{{Key: "$group", Value: bson.M{"_id": "$" + field, "ids": bson.M{"$push": "$" + db.FieldID}}}},
+			{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+		})
+		if err != nil {
+			return err
+		}
+		var groups []struct {
+			IDs []string `bson:"ids"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return err
+		}
+		// Only the user IDs are logged, not the contact details
+		for _, g := range groups {
+			log.Printf("Users %s share one %s", strings.Join(g.IDs, ", "), field)
+		}
+		duplicates += len(groups)
+	}
+
+	if duplicates > 0 {
+		return fmt.Errorf("%d emails or phone numbers belong to more than one user; leave each on one account and run the migration again", duplicates)
+	}
+	log.Printf("No users share an email or phone number")
+	return nil
+}
@@ -0,0 +1,633 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongoStore builds repositories backed by the given MongoDB database.
+// Every operation is bounded by timeout in addition to the caller's context.
+func NewMongoStore(database *mongo.Database, timeout time.Duration) *Store {
+	collection := func(name string) mongoCollection {
+		return mongoCollection{collection: database.Collection(name), timeout: timeout}
+	}
+	return &Store{
+		Users:       &MongoUserRepository{collection(UsersCollection)},
+		Preferences: &MongoPreferencesRepository{collection(PreferencesCollection)},
+		OTPs:        &MongoOTPRepository{collection(OTPCollection)},
+		Exports:     &MongoExportRepository{collection(ExportsCollection)},
+		Identities:  &MongoIdentityRepository{collection(IdentitiesCollection)},
+		Passkeys:    &MongoPasskeyRepository{collection(PasskeysCollection)},
+	}
+}
+
+// mongoCollection is a collection with a per-operation timeout
+type mongoCollection struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// withTimeout derives the context for a single operation
+func (c mongoCollection) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// MongoUserRepository is the MongoDB implementation of UserRepository
+type MongoUserRepository struct {
+	mongoCollection
+}
+
+func (r *MongoUserRepository) findOne(ctx context.Context, filter bson.M) (User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var user User
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return User{}, ErrUserNotFound
+		}
+		log.Printf("Error fetching user by %v: %v", filter, err)
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetByID fetches a user by their ID
+func (r *MongoUserRepository) GetByID(ctx context.Context, id string) (User, error) {
+	return r.findOne(ctx, bson.M{FieldID: id})
+}
+
+// GetByEmail fetches a user by their email
+func (r *MongoUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	if email == "" {
+		return User{}, ErrUserNotFound
+	}
+	return r.findOne(ctx, bson.M{FieldEmail: email})
+}
+
+// GetByPhone fetches a user by their phone number
+func (r *MongoUserRepository) GetByPhone(ctx context.Context, phone string) (User, error) {
+	if phone == "" {
+		return User{}, ErrUserNotFound
+	}
+	return r.findOne(ctx, bson.M{FieldPhone: phone})
+}
+
+// ListCandidates retrieves verified users with zodiacSign that are not awaiting
+// deletion, using the users_verified_sign index
+func (r *MongoUserRepository) ListCandidates(ctx context.Context, zodiacSign string) ([]User, error) {
+	if zodiacSign == "" {
+		return nil, nil
+	}
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{
+		FieldIsVerified: true,
+		FieldZodiacSign: zodiacSign,
+		FieldDeletedAt:  nil,
+	})
+	if err != nil {
+		log.Printf("Failed to fetch users: %v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Printf("Failed to decode users: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// Create inserts a new user
+func (r *MongoUserRepository) Create(ctx context.Context, user User) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	_, err := r.collection.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateUser
+		}
+		log.Printf("Failed to create user: %v", err)
+		return err
+	}
+	return nil
+}
+
+// UpdateFields applies a partial update guarded by the user's version
+func (r *MongoUserRepository) UpdateFields(ctx context.Context, id string, fields map[string]interface{}, expectedVersion int64) (User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{FieldID: id}
+	if expectedVersion != AnyVersion {
+		if expectedVersion == 0 {
+			// Documents written before versioning have no version field
+			filter[FieldVersion] = bson.M{"$in": bson.A{0, nil}}
+		} else {
+			filter[FieldVersion] = expectedVersion
+		}
+	}
+
+	set, unset := splitFields(fields)
+	update := bson.M{"$inc": bson.M{FieldVersion: 1}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var user User
+	err := r.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		// Distinguish a missing user from a stale version
+		if _, getErr := r.GetByID(ctx, id); getErr != nil {
+			return User{}, getErr
+		}
+		return User{}, ErrVersionMismatch
+	}
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return User{}, ErrDuplicateUser
+		}
+		log.Printf("Failed to update user profile: %v", err)
+		return User{}, err
+	}
+	return user, nil
+}
+
+// SetVerified marks a user's email or phone as verified
+func (r *MongoUserRepository) SetVerified(ctx context.Context, id string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{FieldID: id}, bson.M{"$set": bson.M{FieldIsVerified: true}})
+	if err != nil {
+		log.Printf("Failed to update verification status for %s: %v", id, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ListDeletedBefore retrieves users whose deletion was requested before cutoff
+func (r *MongoUserRepository) ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{FieldDeletedAt: bson.M{"$lt": cutoff}})
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteIfDeletedBefore removes a user document whose deletion was requested before cutoff
+func (r *MongoUserRepository) DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{FieldID: id, FieldDeletedAt: bson.M{"$lt": cutoff}})
+	if err != nil {
+		log.Printf("Failed to delete user %s: %v", id, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MongoPreferencesRepository is the MongoDB implementation of PreferencesRepository
+type MongoPreferencesRepository struct {
+	mongoCollection
+}
+
+// Get fetches preferences for a user
+func (r *MongoPreferencesRepository) Get(ctx context.Context, userID string) (UserPreferences, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var prefs UserPreferences
+	err := r.collection.FindOne(ctx, bson.M{FieldUserID: userID}).Decode(&prefs)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return UserPreferences{}, ErrPreferencesNotFound
+		}
+		return UserPreferences{}, err
+	}
+	return prefs, nil
+}
+
+// GetMany fetches preferences for several users in one query
+func (r *MongoPreferencesRepository) GetMany(ctx context.Context, userIDs []string) (map[string]UserPreferences, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{FieldUserID: bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+
+	var all []UserPreferences
+	if err := cursor.All(ctx, &all); err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string]UserPreferences, len(all))
+	for _, prefs := range all {
+		byUser[prefs.UserID] = prefs
+	}
+	return byUser, nil
+}
+
+// Upsert updates preferences for a user, inserting them on first save
+func (r *MongoPreferencesRepository) Upsert(ctx context.Context, userID string, prefs UserPreferences) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	prefs.UserID = userID
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{FieldUserID: userID},
+		bson.M{"$set": prefs},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		log.Printf("Failed to update user preferences: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Delete removes a user's preferences
+func (r *MongoPreferencesRepository) Delete(ctx context.Context, userID string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{FieldUserID: userID})
+	return err
+}
+
+// MongoOTPRepository is the MongoDB implementation of OTPRepository
+type MongoOTPRepository struct {
+	mongoCollection
+}
+
+// Save stores an OTP
+func (r *MongoOTPRepository) Save(ctx context.Context, otp UserOTP) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, otp)
+	if err != nil {
+		log.Printf("Failed to store OTP for %s: %v", otp.Identifier, err)
+	}
+	return err
+}
+
+// ListValid returns the unexpired OTPs for identifier
+func (r *MongoOTPRepository) ListValid(ctx context.Context, identifier string, now time.Time) ([]UserOTP, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: FieldIdentifier, Value: identifier},
+		{Key: FieldExpiresAt, Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var otps []UserOTP
+	if err := cursor.All(ctx, &otps); err != nil {
+		return nil, err
+	}
+	return otps, nil
+}
+
+// DeleteByIdentifier removes every OTP issued to identifier
+func (r *MongoOTPRepository) DeleteByIdentifier(ctx context.Context, identifier string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{FieldIdentifier: identifier})
+	return err
+}
+
+// MongoExportRepository is the MongoDB implementation of ExportRepository
+type MongoExportRepository struct {
+	mongoCollection
+}
+
+// Create stores a new export job
+func (r *MongoExportRepository) Create(ctx context.Context, job ExportJob) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, job)
+	return err
+}
+
+// Latest fetches the user's newest export job
+func (r *MongoExportRepository) Latest(ctx context.Context, userID string) (ExportJob, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var job ExportJob
+	err := r.collection.FindOne(ctx, bson.M{FieldUserID: userID},
+		options.FindOne().SetSort(bson.D{{Key: FieldCreatedAt, Value: -1}}),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return ExportJob{}, ErrExportNotFound
+	}
+	return job, err
+}
+
+// ClaimPending atomically moves the oldest claimable job to running
+func (r *MongoExportRepository) ClaimPending(ctx context.Context, now, staleBefore time.Time) (ExportJob, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{FieldStatus: ExportPending},
+		bson.M{FieldStatus: ExportRunning, FieldStartedAt: bson.M{"$lt": staleBefore}},
+	}}
+	update := bson.M{"$set": bson.M{FieldStatus: ExportRunning, FieldStartedAt: now}}
+
+	var job ExportJob
+	err := r.collection.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: FieldCreatedAt, Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return ExportJob{}, ErrExportNotFound
+	}
+	return job, err
+}
+
+// Save replaces an export job
+func (r *MongoExportRepository) Save(ctx context.Context, job ExportJob) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.ReplaceOne(ctx, bson.M{FieldID: job.ID}, job)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrExportNotFound
+	}
+	return nil
+}
+
+// ListByUser fetches every export job of a user
+func (r *MongoExportRepository) ListByUser(ctx context.Context, userID string) ([]ExportJob, error) {
+	return r.list(ctx, bson.M{FieldUserID: userID})
+}
+
+// ListExpired fetches export jobs past their expiry
+func (r *MongoExportRepository) ListExpired(ctx context.Context, now time.Time) ([]ExportJob, error) {
+	return r.list(ctx, bson.M{FieldExpiresAt: bson.M{"$lt": now}})
+}
+
+func (r *MongoExportRepository) list(ctx context.Context, filter bson.M) ([]ExportJob, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []ExportJob
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Delete removes an export job
+func (r *MongoExportRepository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.DeleteOne(ctx, bson.M{FieldID: id})
+	return err
+}
+
+// MongoIdentityRepository is the MongoDB implementation of IdentityRepository
+type MongoIdentityRepository struct {
+	mongoCollection
+}
+
+// Create links a new identity
+func (r *MongoIdentityRepository) Create(ctx context.Context, identity Identity) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, identity)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateIdentity
+	}
+	return err
+}
+
+// Find fetches the identity for a provider subject
+func (r *MongoIdentityRepository) Find(ctx context.Context, provider, subject string) (Identity, error) {
+	return r.findOne(ctx, bson.M{FieldProvider: provider, FieldSubject: subject})
+}
+
+// FindVerifiedByEmail fetches an identity with a verified email
+func (r *MongoIdentityRepository) FindVerifiedByEmail(ctx context.Context, email string) (Identity, error) {
+	return r.findOne(ctx, bson.M{FieldEmail: email, FieldVerified: true})
+}
+
+func (r *MongoIdentityRepository) findOne(ctx context.Context, filter bson.M) (Identity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var identity Identity
+	err := r.collection.FindOne(ctx, filter).Decode(&identity)
+	if err == mongo.ErrNoDocuments {
+		return Identity{}, ErrIdentityNotFound
+	}
+	return identity, err
+}
+
+// ListByUser fetches every identity linked to a user, oldest first
+func (r *MongoIdentityRepository) ListByUser(ctx context.Context, userID string) ([]Identity, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{FieldUserID: userID},
+		options.Find().SetSort(bson.D{{Key: FieldLinkedAt, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []Identity
+	if err := cursor.All(ctx, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// MarkVerified flags an identity as verified
+func (r *MongoIdentityRepository) MarkVerified(ctx context.Context, provider, subject string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{FieldProvider: provider, FieldSubject: subject},
+		bson.M{"$set": bson.M{FieldVerified: true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// Delete unlinks an identity
+func (r *MongoIdentityRepository) Delete(ctx context.Context, provider, subject string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{FieldProvider: provider, FieldSubject: subject})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// DeleteByUser unlinks every identity of a user
+func (r *MongoIdentityRepository) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{FieldUserID: userID})
+	return err
+}
+
+// MongoPasskeyRepository is the MongoDB implementation of PasskeyRepository
+type MongoPasskeyRepository struct {
+	mongoCollection
+}
+
+// Create stores a new passkey
+func (r *MongoPasskeyRepository) Create(ctx context.Context, passkey Passkey) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, passkey)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicatePasskey
+	}
+	return err
+}
+
+// FindByCredentialID fetches the passkey with a WebAuthn credential ID
+func (r *MongoPasskeyRepository) FindByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var passkey Passkey
+	err := r.collection.FindOne(ctx, bson.M{FieldCredentialID: credentialID}).Decode(&passkey)
+	if err == mongo.ErrNoDocuments {
+		return Passkey{}, ErrPasskeyNotFound
+	}
+	return passkey, err
+}
+
+// ListByUser fetches a user's passkeys, oldest first
+func (r *MongoPasskeyRepository) ListByUser(ctx context.Context, userID string) ([]Passkey, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{FieldUserID: userID},
+		options.Find().SetSort(bson.D{{Key: FieldCreatedAt, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var passkeys []Passkey
+	if err := cursor.All(ctx, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// RecordUse updates the counter and backup state after a login
+func (r *MongoPasskeyRepository) RecordUse(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{FieldID: id}, bson.M{"$set": bson.M{
+		FieldSignCount:   signCount,
+		FieldBackupState: backupState,
+		FieldLastUsedAt:  usedAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// Delete removes one of a user's passkeys
+func (r *MongoPasskeyRepository) Delete(ctx context.Context, userID, id string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{FieldID: id, FieldUserID: userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// DeleteByUser removes every passkey of a user
+func (r *MongoPasskeyRepository) DeleteByUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.collection.DeleteMany(ctx, bson.M{FieldUserID: userID})
+	return err
+}
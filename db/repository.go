@@ -0,0 +1,125 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Errors shared by every repository implementation
+var (
+	ErrUserNotFound        = NotFound("user not found")
+	ErrDuplicateUser       = Conflict("user already exists")
+	ErrPreferencesNotFound = NotFound("preferences not found")
+	ErrVersionMismatch     = PreconditionFailed("user was modified by another request")
+	ErrExportNotFound      = NotFound("export not found")
+	ErrIdentityNotFound    = NotFound("identity not found")
+	ErrDuplicateIdentity   = Conflict("this login is already linked to an account")
+	ErrPasskeyNotFound     = NotFound("passkey not found")
+	ErrDuplicatePasskey    = Conflict("this passkey is already registered")
+)
+
+// AnyVersion skips the optimistic concurrency check in UpdateFields
+const AnyVersion int64 = -1
+
+// UserRepository stores user accounts
+type UserRepository interface {
+	GetByID(ctx context.Context, id string) (User, error)
+	// GetByEmail and GetByPhone return ErrUserNotFound for an empty identifier,
+	// which would otherwise match accounts that have none
+	GetByEmail(ctx context.Context, email string) (User, error)
+	GetByPhone(ctx context.Context, phone string) (User, error)
+	// ListCandidates returns the verified accounts with zodiacSign that are not
+	// awaiting deletion, the pool matchmaking draws from. Accounts without a sign
+	// have no candidates.
+	ListCandidates(ctx context.Context, zodiacSign string) ([]User, error)
+	// Create inserts user, generating an ID when it has none. It returns
+	// ErrDuplicateUser when the ID, email or phone is already taken.
+	Create(ctx context.Context, user User) error
+	// UpdateFields sets (or, for nil values, unsets) the given document fields and
+	// bumps the version. It returns ErrVersionMismatch when expectedVersion is not
+	// AnyVersion and differs from the stored version, and ErrDuplicateUser when
+	// the new email or phone belongs to another user.
+	UpdateFields(ctx context.Context, id string, fields map[string]interface{}, expectedVersion int64) (User, error)
+	SetVerified(ctx context.Context, id string) error
+	// ListDeletedBefore returns soft-deleted users whose deletion was requested before cutoff
+	ListDeletedBefore(ctx context.Context, cutoff time.Time) ([]User, error)
+	// DeleteIfDeletedBefore removes the user document permanently if its deletion
+	// was requested before cutoff. It returns ErrUserNotFound when there is no such
+	// user, e.g. because logging in cancelled the deletion.
+	DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error
+}
+
+// PreferencesRepository stores matchmaking preferences
+type PreferencesRepository interface {
+	Get(ctx context.Context, userID string) (UserPreferences, error)
+	// GetMany returns the preferences of every listed user that has saved any
+	GetMany(ctx context.Context, userIDs []string) (map[string]UserPreferences, error)
+	// Upsert replaces the user's preferences, creating them if needed
+	Upsert(ctx context.Context, userID string, prefs UserPreferences) error
+	// Delete removes the user's preferences; it is not an error if there are none
+	Delete(ctx context.Context, userID string) error
+}
+
+// OTPRepository stores one-time passwords awaiting verification
+type OTPRepository interface {
+	Save(ctx context.Context, otp UserOTP) error
+	// ListValid returns the OTPs for identifier that have not expired at now.
+	// Codes are compared by the caller, in constant time.
+	ListValid(ctx context.Context, identifier string, now time.Time) ([]UserOTP, error)
+	DeleteByIdentifier(ctx context.Context, identifier string) error
+}
+
+// ExportRepository stores data export jobs
+type ExportRepository interface {
+	Create(ctx context.Context, job ExportJob) error
+	// Latest returns the user's most recently requested export
+	Latest(ctx context.Context, userID string) (ExportJob, error)
+	// ClaimPending marks the oldest pending job, or a running job started before
+	// staleBefore (its worker died), as running and returns it. It returns
+	// ErrExportNotFound when there is nothing to do.
+	ClaimPending(ctx context.Context, now, staleBefore time.Time) (ExportJob, error)
+	// Save replaces the stored job with the same ID
+	Save(ctx context.Context, job ExportJob) error
+	ListByUser(ctx context.Context, userID string) ([]ExportJob, error)
+	// ListExpired returns jobs whose ExpiresAt is before now
+	ListExpired(ctx context.Context, now time.Time) ([]ExportJob, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// IdentityRepository stores the login methods linked to each account
+type IdentityRepository interface {
+	// Create links identity to its user, returning ErrDuplicateIdentity when the
+	// provider subject already belongs to an account
+	Create(ctx context.Context, identity Identity) error
+	Find(ctx context.Context, provider, subject string) (Identity, error)
+	// FindVerifiedByEmail returns an identity whose verified email is email
+	FindVerifiedByEmail(ctx context.Context, email string) (Identity, error)
+	ListByUser(ctx context.Context, userID string) ([]Identity, error)
+	MarkVerified(ctx context.Context, provider, subject string) error
+	Delete(ctx context.Context, provider, subject string) error
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// PasskeyRepository stores WebAuthn credentials
+type PasskeyRepository interface {
+	// Create stores a new passkey, returning ErrDuplicatePasskey when the
+	// credential ID is already registered
+	Create(ctx context.Context, passkey Passkey) error
+	FindByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error)
+	ListByUser(ctx context.Context, userID string) ([]Passkey, error)
+	// RecordUse stores the state reported by the authenticator at a login
+	RecordUse(ctx context.Context, id string, signCount uint32, backupState bool, usedAt time.Time) error
+	// Delete removes a passkey of userID
+	Delete(ctx context.Context, userID, id string) error
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Store groups the repositories handlers depend on
+type Store struct {
+	Users       UserRepository
+	Preferences PreferencesRepository
+	OTPs        OTPRepository
+	Exports     ExportRepository
+	Identities  IdentityRepository
+	Passkeys    PasskeyRepository
+}
@@ -0,0 +1,78 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// Connections tracks long-lived hijacked connections such as WebSockets,
+// which http.Server.Shutdown neither closes nor waits for
+type Connections struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	nextID int
+	closed bool
+	notify map[int]func()
+}
+
+// NewConnections creates an empty connection tracker
+func NewConnections() *Connections {
+	return &Connections{notify: make(map[int]func())}
+}
+
+// Track registers a connection. onShutdown is called when draining starts and should
+// ask the peer to close (e.g. send a WebSocket close frame); the returned release
+// func must be called once the connection has finished. ok is false when the
+// server is already shutting down and the connection should be refused.
+func (c *Connections) Track(onShutdown func()) (release func(), ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return func() {}, false
+	}
+
+	id := c.nextID
+	c.nextID++
+	c.notify[id] = onShutdown
+	c.wg.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.notify, id)
+			c.mu.Unlock()
+			c.wg.Done()
+		})
+	}, true
+}
+
+// Close refuses new connections and asks every tracked one to close. It is
+// meant for http.Server.RegisterOnShutdown, so peers hear about the shutdown as
+// soon as it starts; calls after the first do nothing.
+func (c *Connections) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	callbacks := make([]func(), 0, len(c.notify))
+	for _, fn := range c.notify {
+		callbacks = append(callbacks, fn)
+	}
+	c.mu.Unlock()
+
+	for _, fn := range callbacks {
+		if fn != nil {
+			fn()
+		}
+	}
+}
+
+// Drain closes the tracker and waits for tracked connections until ctx expires
+func (c *Connections) Drain(ctx context.Context) error {
+	c.Close()
+	return wait(ctx, &c.wg)
+}
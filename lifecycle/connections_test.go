@@ -0,0 +1,49 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainNotifiesAndWaits(t *testing.T) {
+	c := NewConnections()
+	notified := make(chan struct{})
+	release, ok := c.Track(func() { close(notified) })
+	if !ok {
+		t.Fatal("connection refused before shutdown")
+	}
+
+	go func() {
+		<-notified
+		release()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	if _, ok := c.Track(nil); ok {
+		t.Error("connection accepted after shutdown")
+	}
+}
+
+func TestCloseNotifiesOnce(t *testing.T) {
+	c := NewConnections()
+	calls := 0
+	release, _ := c.Track(func() { calls++ })
+	defer release()
+
+	// RegisterOnShutdown calls Close, and Drain closes again
+	c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain with an open connection: got %v, want DeadlineExceeded", err)
+	}
+	if calls != 1 {
+		t.Errorf("shutdown callback ran %d times, want 1", calls)
+	}
+}
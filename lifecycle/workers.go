@@ -0,0 +1,53 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Workers runs background goroutines that are cancelled together at shutdown
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWorkers creates an empty worker group
+func NewWorkers() *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{ctx: ctx, cancel: cancel}
+}
+
+// Go starts fn in the background; fn must return once ctx is cancelled
+func (w *Workers) Go(name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		log.Printf("Worker %s started", name)
+		fn(w.ctx)
+		log.Printf("Worker %s stopped", name)
+	}()
+}
+
+// Stop cancels all workers and waits for them until ctx expires
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+	return wait(ctx, &w.wg)
+}
+
+// wait blocks until wg is done or ctx expires
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,29 +1,181 @@
 package main
 
 import (
+	"astromatch/account"
 	"astromatch/api"
+	"astromatch/auth"
+	"astromatch/cache"
+	"astromatch/config"
 	"astromatch/db"
+	"astromatch/lifecycle"
+	"astromatch/notify"
+	"astromatch/photos"
+	"astromatch/ratelimit"
+	"astromatch/storage"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or JSON config file")
+	flag.Parse()
+
+	// Load and validate configuration
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	//initialise db connection
-	db.InitDB()
+	db.InitDB(cfg.Mongo)
+
+	// Apply declared indexes and report drift; signup relies on the unique ones
+	// to reject duplicate accounts, so the server does not start without them
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), time.Minute)
+	if err := db.ApplyIndexes(indexCtx, db.Database()); err != nil {
+		log.Fatalf("Failed to apply MongoDB indexes: %v", err)
+	}
+	cancelIndexes()
+
+	// Initialise redis client
+	cache.InitRedis(cfg.Redis)
+
+	// Apply auth secrets
+	auth.Init(cfg.Auth)
 
-	// Setup API routes
-	api.SetupRoutes()
+	// Select email and SMS providers
+	if err := notify.Init(cfg.Notify); err != nil {
+		log.Fatal("Failed to configure notifications:", err)
+	}
+
+	// Select the blob store for uploaded photos
+	if err := storage.Init(cfg.Storage); err != nil {
+		log.Fatal("Failed to configure storage:", err)
+	}
+	photos.Init(cfg.Photos)
+	account.Init(cfg.Account)
+	ratelimit.Init(cfg.RateLimit)
+	api.InitCORS(cfg.CORS)
+
+	// Setup API routes on the MongoDB-backed repositories
+	mux := http.NewServeMux()
+	store := db.NewMongoStore(db.Database(), cfg.Mongo.OperationTimeout)
+	api.SetupRoutes(mux, store)
+
+	// Serve uploaded files when they are kept on local disk behind a relative URL
+	if local, ok := storage.Blobs.(*storage.LocalStore); ok && strings.HasPrefix(local.BaseURL, "/") {
+		prefix := strings.TrimRight(local.BaseURL, "/") + "/"
+		mux.Handle(prefix, http.StripPrefix(prefix, local.Handler()))
+	}
 
 	// Root endpoint to confirm service is running
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, " 💫 🪐 🔮 ✨ Welcome to ⋆｡ﾟ☁︎｡⋆｡ ﾟ☾ ﾟ｡⋆ ASTROTALK ⋆｡ﾟ☁︎｡⋆｡ ﾟ☾ ﾟ｡⋆ ✨ 🪐 🔮  💫 ")
 	})
 
-	// Log that services are running
-	log.Println("🌟 AstroMatch services are running on port 8080 🌟")
+	// Background workers share the application's lifetime
+	workers := lifecycle.NewWorkers()
+	workers.Go("account-purge", func(ctx context.Context) { account.RunPurger(ctx, store) })
+	workers.Go("data-export", func(ctx context.Context) { account.RunExporter(ctx, store) })
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           api.RequestIDMiddleware(api.CORSMiddleware(mux)),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	// Shutdown does not see hijacked connections such as WebSockets; tell them as it starts
+	srv.RegisterOnShutdown(api.Connections.Close)
 
 	// Start the server
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("🌟 AstroMatch services are running on %s 🌟", cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	// Wait for a termination signal or a fatal server error
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, draining connections")
+		api.BeginShutdown()
+		time.Sleep(cfg.Server.DrainDelay)
+	case err := <-serverErr:
+		if err != nil {
+			log.Printf("HTTP server failed: %v", err)
+		}
+	}
+
+	shutdown(srv, workers, cfg.Server.ShutdownTimeout)
+}
+
+// shutdown drains in-flight requests and long-lived connections and stops
+// background workers side by side, each within the given deadline, then closes
+// the database and cache clients
+func shutdown(srv *http.Server, workers *lifecycle.Workers, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Draining in parallel keeps a slow request from using up the others' budget
+	results := make(chan error, 3)
+	go func() {
+		if err := srv.Shutdown(ctx); err != nil {
+			results <- fmt.Errorf("HTTP server shutdown incomplete: %w", err)
+			return
+		}
+		results <- nil
+	}()
+	go func() {
+		if err := api.Connections.Drain(ctx); err != nil {
+			results <- fmt.Errorf("long-lived connections not drained: %w", err)
+			return
+		}
+		results <- nil
+	}()
+	go func() {
+		if err := workers.Stop(ctx); err != nil {
+			results <- fmt.Errorf("background workers did not stop in time: %w", err)
+			return
+		}
+		results <- nil
+	}()
+
+	clean := true
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			log.Print(err)
+			clean = false
+		}
+	}
+	if err := cache.Close(); err != nil {
+		log.Printf("Failed to close Redis: %v", err)
+		clean = false
+	}
+	if err := db.Close(ctx); err != nil {
+		log.Printf("Failed to disconnect MongoDB: %v", err)
+		clean = false
+	}
+
+	if clean {
+		log.Println("AstroMatch shut down cleanly")
+	} else {
+		log.Println("AstroMatch shut down with errors")
+	}
 }
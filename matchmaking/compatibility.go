@@ -2,6 +2,7 @@ package matchmaking
 
 import (
 	"astromatch/db"
+	"context"
 	"math/rand"
 )
 
@@ -66,17 +67,37 @@ func GetZodiacCompatibility(sign1, sign2 string) int {
 	return rand.Intn(100)
 }
 
-func FindCompatibleUsers(user db.User) ([]db.User, error) {
-	allUsers, err := db.GetAllUsers()
+// FindCompatibleUsers returns candidates from users that are compatible with user.
+// Both sides must be interested in the other's gender, and only verified accounts
+// are offered.
+func FindCompatibleUsers(ctx context.Context, users db.UserRepository, user db.User) ([]db.User, error) {
+	// Example compatibility logic: candidates share the user's sign
+	candidates, err := users.ListCandidates(ctx, user.ZodiacSign)
 	if err != nil {
 		return nil, err
 	}
 
 	var compatibleUsers []db.User
-	for _, u := range allUsers {
-		if u.ZodiacSign == user.ZodiacSign { // Example compatibility logic
-			compatibleUsers = append(compatibleUsers, u)
+	for _, u := range candidates {
+		if u.ID == user.ID || !interestedIn(user, u) || !interestedIn(u, user) {
+			continue
 		}
+		compatibleUsers = append(compatibleUsers, u)
 	}
 	return compatibleUsers, nil
 }
+
+// interestedIn reports whether seeker wants to meet candidate. A seeker without
+// stated preferences is open to anyone; a seeker with preferences only matches
+// candidates whose gender is known and listed.
+func interestedIn(seeker, candidate db.User) bool {
+	if len(seeker.InterestedIn) == 0 {
+		return true
+	}
+	for _, gender := range seeker.InterestedIn {
+		if gender == candidate.Gender {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package matchmaking
+
+import (
+	"astromatch/db"
+	"astromatch/db/memory"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFindCompatibleUsers(t *testing.T) {
+	women := []string{db.GenderWoman}
+	men := []string{db.GenderMan}
+	tests := []struct {
+		name       string
+		seeker     db.User
+		candidates []db.User
+		want       []string
+	}{
+		{
+			name:   "mutual interest",
+			seeker: db.User{ID: "me", Gender: db.GenderMan, InterestedIn: women},
+			candidates: []db.User{
+				{ID: "a", Gender: db.GenderWoman, InterestedIn: men},
+				{ID: "b", Gender: db.GenderMan, InterestedIn: men},
+			},
+			want: []string{"a"},
+		},
+		{
+			name:   "one-sided interest",
+			seeker: db.User{ID: "me", Gender: db.GenderMan, InterestedIn: women},
+			candidates: []db.User{
+				{ID: "a", Gender: db.GenderWoman, InterestedIn: women},
+				{ID: "b", Gender: db.GenderWoman, InterestedIn: []string{db.GenderNonBinary}},
+			},
+			want: nil,
+		},
+		{
+			name:   "no preferences on either side",
+			seeker: db.User{ID: "me", Gender: db.GenderNonBinary},
+			candidates: []db.User{
+				{ID: "a", Gender: db.GenderWoman},
+				{ID: "b", Gender: db.GenderMan, InterestedIn: men},
+				{ID: "c"},
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name:   "candidate of unknown gender",
+			seeker: db.User{ID: "me", Gender: db.GenderWoman, InterestedIn: women},
+			candidates: []db.User{
+				{ID: "a"},
+				{ID: "b", Gender: db.GenderWoman},
+			},
+			want: []string{"b"},
+		},
+		{
+			name:   "seeker of unknown gender",
+			seeker: db.User{ID: "me"},
+			candidates: []db.User{
+				{ID: "a", Gender: db.GenderWoman, InterestedIn: men},
+				{ID: "b", Gender: db.GenderMan},
+			},
+			want: []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			users := memory.NewUserRepository()
+			// The seeker is a candidate too, and never their own match
+			tt.seeker.ZodiacSign, tt.seeker.IsVerified = "Leo", true
+			for _, u := range append(tt.candidates, tt.seeker) {
+				u.ZodiacSign, u.IsVerified = "Leo", true
+				if err := users.Create(ctx, u); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			matches, err := FindCompatibleUsers(ctx, users, tt.seeker)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, u := range matches {
+				got = append(got, u.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
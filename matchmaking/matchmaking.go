@@ -3,29 +3,64 @@ package matchmaking
 import (
 	"astromatch/auth"
 	"astromatch/db"
-	"encoding/json"
+	"astromatch/profile"
+	"astromatch/respond"
+	"errors"
 	"net/http"
 )
 
-func MatchHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("token")
+// Handler serves matchmaking endpoints using the injected repositories
+type Handler struct {
+	store *db.Store
+}
+
+// NewHandler creates matchmaking handlers backed by store
+func NewHandler(store *db.Store) *Handler {
+	return &Handler{store: store}
+}
+
+// Match returns users compatible with the authenticated user
+func (h *Handler) Match(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	user, err := h.store.Users.GetByID(r.Context(), claims.UserID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		respond.Error(w, r, http.StatusNotFound, respond.CodeNotFound, "User not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		respond.DBError(w, r, err, "Failed to load user")
 		return
 	}
 
-	claims, err := auth.ValidateJWT(cookie.Value)
+	compatibleUsers, err := FindCompatibleUsers(r.Context(), h.store.Users, user)
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		respond.DBError(w, r, err, "Failed to find matches")
 		return
 	}
 
-	user, err := db.GetUserByID(claims.UserID)
+	ids := make([]string, len(compatibleUsers))
+	for i, u := range compatibleUsers {
+		ids[i] = u.ID
+	}
+	prefsByUser, err := h.store.Preferences.GetMany(r.Context(), ids)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		respond.DBError(w, r, err, "Failed to find matches")
 		return
 	}
 
-	compatibleUsers, _ := FindCompatibleUsers(user)
-	json.NewEncoder(w).Encode(compatibleUsers)
+	// Only public cards leave the server
+	cards := make([]profile.Public, 0, len(compatibleUsers))
+	for _, candidate := range compatibleUsers {
+		var prefs *db.UserPreferences
+		if p, ok := prefsByUser[candidate.ID]; ok {
+			prefs = &p
+		}
+		cards = append(cards, profile.NewPublic(candidate, prefs, user))
+	}
+	respond.JSON(w, http.StatusOK, cards)
 }
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	brevoAPI     = "https://api.brevo.com/v3"
+	brevoTimeout = 10 * time.Second
+)
+
+// BrevoSender sends email and SMS through the Brevo HTTP API
+type BrevoSender struct {
+	APIKey    string
+	From      string
+	SMSSender string
+
+	client *resty.Client
+}
+
+// NewBrevoSender returns a sender whose requests give up after brevoTimeout
+func NewBrevoSender(apiKey, from, smsSender string) *BrevoSender {
+	return &BrevoSender{
+		APIKey:    apiKey,
+		From:      from,
+		SMSSender: smsSender,
+		client:    resty.New().SetTimeout(brevoTimeout),
+	}
+}
+
+// SendEmail delivers msg through Brevo's transactional email endpoint
+func (b *BrevoSender) SendEmail(msg Message) error {
+	body := map[string]interface{}{
+		"sender":      map[string]string{"email": b.From},
+		"to":          []map[string]string{{"email": msg.To}},
+		"subject":     msg.Subject,
+		"textContent": msg.Text,
+	}
+	if msg.HTML != "" {
+		body["htmlContent"] = msg.HTML
+	}
+	return b.post("/smtp/email", body)
+}
+
+// SendSMS delivers content through Brevo's transactional SMS endpoint
+func (b *BrevoSender) SendSMS(to, content string) error {
+	return b.post("/transactionalSMS/sms", map[string]interface{}{
+		"sender":    b.SMSSender,
+		"recipient": to,
+		"content":   content,
+	})
+}
+
+func (b *BrevoSender) post(path string, body interface{}) error {
+	response, err := b.client.R().
+		SetHeader("accept", "application/json").
+		SetHeader("api-key", b.APIKey).
+		SetHeader("content-type", "application/json").
+		SetBody(body).
+		Post(brevoAPI + path)
+	if err != nil {
+		return err
+	}
+	if response.IsError() {
+		return fmt.Errorf("brevo %s failed: %s: %s", path, response.Status(), response.String())
+	}
+	return nil
+}
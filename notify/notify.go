@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+)
+
+// Message represents an outgoing email
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// EmailSender delivers email messages
+type EmailSender interface {
+	SendEmail(msg Message) error
+}
+
+// SMSSender delivers text messages to a phone number
+type SMSSender interface {
+	SendSMS(to, content string) error
+}
+
+// Config selects and configures the email and SMS providers
+type Config struct {
+	EmailProvider string `yaml:"emailProvider" json:"emailProvider" env:"EMAIL_PROVIDER"` // "smtp", "brevo" or "outbox"
+	SMSProvider   string `yaml:"smsProvider" json:"smsProvider" env:"SMS_PROVIDER"`       // "brevo" or "outbox"
+
+	SMTPHost     string `yaml:"smtpHost" json:"smtpHost" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtpPort" json:"smtpPort" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtpUsername" json:"smtpUsername" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtpPassword" json:"smtpPassword" env:"SMTP_PASSWORD"`
+	From         string `yaml:"from" json:"from" env:"EMAIL_FROM"`
+
+	BrevoAPIKey    string `yaml:"brevoApiKey" json:"brevoApiKey" env:"BREVO_API_KEY"`
+	BrevoSMSSender string `yaml:"brevoSmsSender" json:"brevoSmsSender" env:"BREVO_SMS_SENDER"`
+
+	// OutboxPath is the file the dev outbox appends to; empty logs to the console
+	OutboxPath string `yaml:"outboxPath" json:"outboxPath" env:"OUTBOX_PATH"`
+
+	// PreviewEnabled exposes the email template preview endpoint
+	PreviewEnabled bool `yaml:"previewEnabled" json:"previewEnabled" env:"EMAIL_PREVIEW_ENABLED"`
+}
+
+// Active senders, set by Init
+var (
+	Email EmailSender
+	SMS   SMSSender
+)
+
+// Init selects the email and SMS senders described by cfg
+func Init(cfg Config) error {
+	email, err := NewEmailSender(cfg)
+	if err != nil {
+		return err
+	}
+	sms, err := NewSMSSender(cfg)
+	if err != nil {
+		return err
+	}
+
+	Email = email
+	SMS = sms
+	PreviewEnabled = cfg.PreviewEnabled
+	log.Printf("Notifications: email via %s, SMS via %s", cfg.EmailProvider, cfg.SMSProvider)
+	return nil
+}
+
+// NewEmailSender builds the email sender named by cfg.EmailProvider
+func NewEmailSender(cfg Config) (EmailSender, error) {
+	switch cfg.EmailProvider {
+	case "smtp":
+		return &SMTPSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}, nil
+	case "brevo":
+		return NewBrevoSender(cfg.BrevoAPIKey, cfg.From, cfg.BrevoSMSSender), nil
+	case "outbox":
+		return NewOutbox(cfg.OutboxPath), nil
+	default:
+		return nil, fmt.Errorf("unknown email provider %q", cfg.EmailProvider)
+	}
+}
+
+// NewSMSSender builds the SMS sender named by cfg.SMSProvider
+func NewSMSSender(cfg Config) (SMSSender, error) {
+	switch cfg.SMSProvider {
+	case "brevo":
+		return NewBrevoSender(cfg.BrevoAPIKey, cfg.From, cfg.BrevoSMSSender), nil
+	case "outbox":
+		return NewOutbox(cfg.OutboxPath), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", cfg.SMSProvider)
+	}
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// OutboxEntry is a single message captured by the dev outbox
+type OutboxEntry struct {
+	Kind    string    `json:"kind"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject,omitempty"`
+	Text    string    `json:"text"`
+	HTML    string    `json:"html,omitempty"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+// Outbox records messages instead of delivering them, for local runs and tests.
+// Entries are appended as JSON lines to Path, or logged to the console when Path is empty.
+type Outbox struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// NewOutbox creates a dev outbox writing to path
+func NewOutbox(path string) *Outbox {
+	return &Outbox{Path: path}
+}
+
+// SendEmail records msg in the outbox
+func (o *Outbox) SendEmail(msg Message) error {
+	return o.record(OutboxEntry{Kind: "email", To: msg.To, Subject: msg.Subject, Text: msg.Text, HTML: msg.HTML})
+}
+
+// SendSMS records the text message in the outbox
+func (o *Outbox) SendSMS(to, content string) error {
+	return o.record(OutboxEntry{Kind: "sms", To: to, Text: content})
+}
+
+func (o *Outbox) record(entry OutboxEntry) error {
+	entry.SentAt = time.Now().UTC()
+
+	if o.Path == "" {
+		// Never the text: it carries one-time codes
+		log.Printf("[outbox] %s to %s: %s", entry.Kind, entry.To, entry.Subject)
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	f, err := os.OpenFile(o.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
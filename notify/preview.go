@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"astromatch/respond"
+	"net/http"
+)
+
+// PreviewEnabled exposes the email preview endpoint, set by Init
+var PreviewEnabled bool
+
+// sampleData returns representative data for previewing each template
+func sampleData(name string) (interface{}, bool) {
+	switch name {
+	case TemplateOTP:
+		return OTPEmail{Name: "Luna", OTP: "482913", ExpiresInMinutes: 5}, true
+	case TemplateWelcome:
+		return WelcomeEmail{Name: "Luna"}, true
+	case TemplatePasswordReset:
+		return PasswordResetEmail{Name: "Luna", ResetURL: "https://astromatch.app/reset?token=preview", ExpiresInMinutes: 30}, true
+	case TemplateNewMatch:
+		return NewMatchEmail{Name: "Luna", MatchName: "Orion", MatchSign: "Sagittarius", Score: 92}, true
+	case TemplateWeeklyDigest:
+		return WeeklyDigestEmail{
+			Name:       "Luna",
+			NewMatches: 3,
+			Highlights: []DigestMatch{
+				{Name: "Orion", Sign: "Sagittarius", Score: 92},
+				{Name: "Vega", Sign: "Aquarius", Score: 88},
+			},
+			Horoscope: "Venus favours bold first messages this week.",
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// PreviewHandler renders a template with sample data for designers.
+// Query parameters: template (required), locale and format ("html" or "text").
+func PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if !PreviewEnabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := r.URL.Query().Get("template")
+	data, ok := sampleData(name)
+	if !ok {
+		respond.Error(w, r, http.StatusNotFound, respond.CodeNotFound, "Unknown template")
+		return
+	}
+
+	msg, err := Render(name, r.URL.Query().Get("locale"), data)
+	if err != nil {
+		respond.Error(w, r, http.StatusInternalServerError, respond.CodeInternal, "Failed to render template: "+err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Subject: " + msg.Subject + "\n\n" + msg.Text))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(msg.HTML))
+}
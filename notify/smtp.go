@@ -0,0 +1,29 @@
+package notify
+
+import (
+	gomail "gopkg.in/gomail.v2"
+)
+
+// SMTPSender sends email through an SMTP relay
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SendEmail delivers msg as plain text, adding an HTML alternative when present
+func (s *SMTPSender) SendEmail(msg Message) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.From)
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+
+	dialer := gomail.NewDialer(s.Host, s.Port, s.Username, s.Password)
+	return dialer.DialAndSend(m)
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// DefaultLocale is used when a user's language has no template variant
+const DefaultLocale = "en"
+
+// Template names available for transactional emails
+const (
+	TemplateOTP           = "otp"
+	TemplateWelcome       = "welcome"
+	TemplatePasswordReset = "password_reset"
+	TemplateNewMatch      = "new_match"
+	TemplateWeeklyDigest  = "weekly_digest"
+)
+
+// OTPEmail is the data for the OTP template
+type OTPEmail struct {
+	Name             string
+	OTP              string
+	ExpiresInMinutes int
+}
+
+// WelcomeEmail is the data for the welcome template
+type WelcomeEmail struct {
+	Name string
+}
+
+// PasswordResetEmail is the data for the password reset template
+type PasswordResetEmail struct {
+	Name             string
+	ResetURL         string
+	ExpiresInMinutes int
+}
+
+// NewMatchEmail is the data for the new match template
+type NewMatchEmail struct {
+	Name      string
+	MatchName string
+	MatchSign string
+	Score     int
+}
+
+// DigestMatch is a single highlighted match in the weekly digest
+type DigestMatch struct {
+	Name  string
+	Sign  string
+	Score int
+}
+
+// WeeklyDigestEmail is the data for the weekly digest template
+type WeeklyDigestEmail struct {
+	Name       string
+	NewMatches int
+	Highlights []DigestMatch
+	Horoscope  string
+}
+
+// ResolveLocale maps a language preference such as "es-MX" to a supported locale
+func ResolveLocale(language string) string {
+	lang := strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if lang == "" {
+		return DefaultLocale
+	}
+	// Locales are directories; files such as layout.html sit beside them
+	if info, err := fs.Stat(templateFS, "templates/"+lang); err != nil || !info.IsDir() {
+		return DefaultLocale
+	}
+	return lang
+}
+
+// Render builds a multipart email from the named template in the given language
+func Render(name, language string, data interface{}) (Message, error) {
+	locale := ResolveLocale(language)
+	base := "templates/" + locale + "/" + name
+
+	textTmpl, err := texttemplate.ParseFS(templateFS, base+".txt")
+	if err != nil {
+		return Message{}, fmt.Errorf("unknown email template %q: %w", name, err)
+	}
+
+	var subject, text bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := textTmpl.ExecuteTemplate(&text, "text", data); err != nil {
+		return Message{}, err
+	}
+
+	htmlTmpl, err := htmltemplate.New("layout.html").Funcs(htmltemplate.FuncMap{
+		"locale":  func() string { return locale },
+		"subject": func() string { return subject.String() },
+	}).ParseFS(templateFS, "templates/layout.html", base+".html")
+	if err != nil {
+		return Message{}, err
+	}
+
+	var html bytes.Buffer
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// SendTemplate renders the named template and sends it to the recipient
+func SendTemplate(to, name, language string, data interface{}) error {
+	msg, err := Render(name, language, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	return Email.SendEmail(msg)
+}
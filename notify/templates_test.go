@@ -0,0 +1,21 @@
+package notify
+
+import "testing"
+
+func TestResolveLocale(t *testing.T) {
+	tests := map[string]string{
+		"":            DefaultLocale,
+		"es":          "es",
+		"es-MX":       "es",
+		" ES_ar ":     "es",
+		"en-GB":       "en",
+		"fr":          DefaultLocale,
+		"layout.html": DefaultLocale,
+		"..":          DefaultLocale,
+	}
+	for language, want := range tests {
+		if got := ResolveLocale(language); got != want {
+			t.Errorf("ResolveLocale(%q) = %q, want %q", language, got, want)
+		}
+	}
+}
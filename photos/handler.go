@@ -0,0 +1,349 @@
+// Package photos handles profile photo upload, ordering and removal
+package photos
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/respond"
+	"astromatch/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config limits photo uploads
+type Config struct {
+	MaxPhotos     int   `yaml:"maxPhotos" json:"maxPhotos" env:"PHOTOS_MAX_COUNT"`
+	MaxUploadSize int64 `yaml:"maxUploadSize" json:"maxUploadSize" env:"PHOTOS_MAX_UPLOAD_BYTES"`
+}
+
+// Active limits, set by Init
+var limits = Config{MaxPhotos: 6, MaxUploadSize: 10 << 20}
+
+// Init applies the upload limits in cfg, keeping defaults for unset values
+func Init(cfg Config) {
+	if cfg.MaxPhotos > 0 {
+		limits.MaxPhotos = cfg.MaxPhotos
+	}
+	if cfg.MaxUploadSize > 0 {
+		limits.MaxUploadSize = cfg.MaxUploadSize
+	}
+}
+
+// maxUpdateAttempts bounds retries when a concurrent profile update bumps the version
+const maxUpdateAttempts = 3
+
+var (
+	errTooManyPhotos = errors.New("too many photos")
+	errPhotoNotFound = db.NotFound("photo not found")
+)
+
+// Handler serves the photo endpoints using the injected repositories and the
+// active blob store
+type Handler struct {
+	store *db.Store
+}
+
+// NewHandler creates photo handlers backed by store
+func NewHandler(store *db.Store) *Handler {
+	return &Handler{store: store}
+}
+
+// Photos lists (GET), uploads (POST) or reorders (PUT) the caller's photos
+func (h *Handler) Photos(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		user, err := h.store.Users.GetByID(r.Context(), claims.UserID)
+		if err != nil {
+			respond.DBError(w, r, err, "Failed to load photos")
+			return
+		}
+		respond.JSON(w, http.StatusOK, photoList(user.Photos))
+	case http.MethodPost:
+		h.upload(w, r, claims.UserID)
+	case http.MethodPut:
+		h.reorder(w, r, claims.UserID)
+	default:
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// Photo deletes (DELETE) one of the caller's photos
+func (h *Handler) Photo(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	photoID := r.PathValue("photoID")
+	var removed db.Photo
+	user, err := h.updatePhotos(r.Context(), claims.UserID, func(photos []db.Photo) ([]db.Photo, error) {
+		for i, p := range photos {
+			if p.ID == photoID {
+				removed = p
+				return append(photos[:i:i], photos[i+1:]...), nil
+			}
+		}
+		return nil, errPhotoNotFound
+	})
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to delete photo")
+		return
+	}
+
+	deleteBlobs(r.Context(), removed)
+	respond.JSON(w, http.StatusOK, photoList(user.Photos))
+}
+
+// upload stores a single multipart "photo" part as a new photo at the end of the list
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request, userID string) {
+	// Fail fast before reading the body when the user is already at the limit
+	user, err := h.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to upload photo")
+		return
+	}
+	if len(user.Photos) >= limits.MaxPhotos {
+		tooManyPhotos(w, r)
+		return
+	}
+
+	data, status, err := readPhotoPart(w, r)
+	if err != nil {
+		code := respond.CodeInvalidRequest
+		switch status {
+		case http.StatusRequestEntityTooLarge:
+			code = respond.CodePayloadTooLarge
+		case http.StatusUnsupportedMediaType:
+			code = respond.CodeUnsupportedMediaType
+		}
+		respond.Error(w, r, status, code, err.Error())
+		return
+	}
+
+	img, err := processImage(data)
+	if errors.Is(err, errUnsupportedType) {
+		respond.Error(w, r, http.StatusUnsupportedMediaType, respond.CodeUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		verr := &db.ValidationError{}
+		verr.Add("photo", err.Error())
+		respond.Validation(w, r, verr)
+		return
+	}
+
+	photoID := uuid.New().String()
+	photo := db.Photo{
+		ID:           photoID,
+		ThumbnailKey: fmt.Sprintf("users/%s/photos/%s/thumbnail.jpg", userID, photoID),
+		MediumKey:    fmt.Sprintf("users/%s/photos/%s/medium.jpg", userID, photoID),
+		Width:        img.width,
+		Height:       img.height,
+		CreatedAt:    time.Now().UTC(),
+	}
+	photo.ThumbnailURL = storage.Blobs.URL(photo.ThumbnailKey)
+	photo.URL = storage.Blobs.URL(photo.MediumKey)
+
+	if err := storage.Blobs.Put(r.Context(), photo.ThumbnailKey, img.thumbnail, "image/jpeg"); err != nil {
+		log.Printf("[%s] Failed to store thumbnail: %v", respond.RequestID(r.Context()), err)
+		respond.Error(w, r, http.StatusBadGateway, respond.CodeUpstreamFailed, "Failed to store photo")
+		return
+	}
+	if err := storage.Blobs.Put(r.Context(), photo.MediumKey, img.medium, "image/jpeg"); err != nil {
+		deleteBlobs(r.Context(), photo)
+		log.Printf("[%s] Failed to store photo: %v", respond.RequestID(r.Context()), err)
+		respond.Error(w, r, http.StatusBadGateway, respond.CodeUpstreamFailed, "Failed to store photo")
+		return
+	}
+
+	user, err = h.updatePhotos(r.Context(), userID, func(photos []db.Photo) ([]db.Photo, error) {
+		if len(photos) >= limits.MaxPhotos {
+			return nil, errTooManyPhotos
+		}
+		return append(photos, photo), nil
+	})
+	if err != nil {
+		deleteBlobs(r.Context(), photo)
+		if errors.Is(err, errTooManyPhotos) {
+			tooManyPhotos(w, r)
+			return
+		}
+		respond.DBError(w, r, err, "Failed to upload photo")
+		return
+	}
+
+	respond.JSON(w, http.StatusCreated, photoList(user.Photos))
+}
+
+// reorder applies {"order": [photo IDs]}, which must list every photo exactly once
+func (h *Handler) reorder(w http.ResponseWriter, r *http.Request, userID string) {
+	var req struct {
+		Order []string `json:"order"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.updatePhotos(r.Context(), userID, func(photos []db.Photo) ([]db.Photo, error) {
+		byID := make(map[string]db.Photo, len(photos))
+		for _, p := range photos {
+			byID[p.ID] = p
+		}
+
+		verr := &db.ValidationError{}
+		if len(req.Order) != len(photos) {
+			verr.Add("order", "must list every photo exactly once")
+			return nil, verr
+		}
+		ordered := make([]db.Photo, 0, len(photos))
+		for _, id := range req.Order {
+			p, ok := byID[id]
+			if !ok {
+				verr.Add("order", "must list every photo exactly once")
+				return nil, verr
+			}
+			delete(byID, id)
+			ordered = append(ordered, p)
+		}
+		return ordered, nil
+	})
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to reorder photos")
+		return
+	}
+
+	respond.JSON(w, http.StatusOK, photoList(user.Photos))
+}
+
+// updatePhotos applies change to the user's photo list and saves it together with
+// ProfilePic, retrying when another update bumped the version in between
+func (h *Handler) updatePhotos(ctx context.Context, userID string, change func([]db.Photo) ([]db.Photo, error)) (db.User, error) {
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		var user db.User
+		user, err = h.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			return db.User{}, err
+		}
+
+		photos, changeErr := change(append([]db.Photo(nil), user.Photos...))
+		if changeErr != nil {
+			return db.User{}, changeErr
+		}
+
+		fields := map[string]interface{}{db.FieldPhotos: photos}
+		if len(photos) == 0 {
+			fields[db.FieldPhotos] = nil
+		}
+		// A picture imported from a login provider is left alone; only one that
+		// came from an upload follows the first photo
+		if user.ProfilePic == "" || isUploadedPhoto(user.Photos, user.ProfilePic) {
+			if len(photos) == 0 {
+				fields[db.FieldProfilePic] = nil
+			} else {
+				fields[db.FieldProfilePic] = photos[0].URL
+			}
+		}
+
+		user, err = h.store.Users.UpdateFields(ctx, userID, fields, user.Version)
+		if !errors.Is(err, db.ErrVersionMismatch) {
+			return user, err
+		}
+	}
+	return db.User{}, err
+}
+
+// isUploadedPhoto reports whether url is the address of one of photos
+func isUploadedPhoto(photos []db.Photo, url string) bool {
+	for _, p := range photos {
+		if p.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// readPhotoPart reads the "photo" part of a multipart body, enforcing the size
+// limit while streaming. It returns the HTTP status to use on failure.
+func readPhotoPart(w http.ResponseWriter, r *http.Request) ([]byte, int, error) {
+	// Leave headroom for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxUploadSize+64<<10)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("upload must be multipart/form-data")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, http.StatusBadRequest, fmt.Errorf("missing photo part")
+		}
+		if err != nil {
+			return nil, uploadErrorStatus(err), fmt.Errorf("invalid multipart body")
+		}
+		if part.FormName() != "photo" {
+			part.Close()
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, limits.MaxUploadSize+1))
+		part.Close()
+		if err != nil {
+			return nil, uploadErrorStatus(err), fmt.Errorf("failed to read photo")
+		}
+		if int64(len(data)) > limits.MaxUploadSize {
+			return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("photo must be at most %d MB", limits.MaxUploadSize>>20)
+		}
+		return data, 0, nil
+	}
+}
+
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func tooManyPhotos(w http.ResponseWriter, r *http.Request) {
+	respond.Error(w, r, http.StatusConflict, respond.CodeTooManyPhotos, fmt.Sprintf("You can have at most %d photos", limits.MaxPhotos))
+}
+
+// deleteBlobs removes both sizes of photo; failures only leave orphaned files
+func deleteBlobs(ctx context.Context, photo db.Photo) {
+	for _, key := range []string{photo.ThumbnailKey, photo.MediumKey} {
+		if err := storage.Blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
+// photoList keeps an empty list encoded as [] rather than null
+func photoList(photos []db.Photo) []db.Photo {
+	if photos == nil {
+		return []db.Photo{}
+	}
+	return photos
+}
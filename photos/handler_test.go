@@ -0,0 +1,102 @@
+package photos
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/db/memory"
+	"astromatch/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestHandler(t *testing.T, users ...db.User) *Handler {
+	t.Helper()
+	previous := storage.Blobs
+	storage.Blobs = storage.NewLocalStore(t.TempDir(), "/media")
+	t.Cleanup(func() { storage.Blobs = previous })
+
+	store := memory.NewStore()
+	for _, u := range users {
+		if err := store.Users.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewHandler(store)
+}
+
+// uploadPhoto posts a valid PNG as userID and returns the stored photo list
+func uploadPhoto(t *testing.T, h *Handler, userID string) []db.Photo {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("photo", "me.png")
+	part.Write(encodePNG(t, halves(400, 400)))
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/users/me/photos", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r = r.WithContext(auth.WithClaims(r.Context(), &auth.Claims{UserID: userID}))
+	w := httptest.NewRecorder()
+	h.Photos(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", w.Code, w.Body)
+	}
+	var photos []db.Photo
+	json.NewDecoder(w.Body).Decode(&photos)
+	return photos
+}
+
+func deletePhoto(t *testing.T, h *Handler, userID, photoID string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodDelete, "/api/v1/users/me/photos/"+photoID, nil)
+	r.SetPathValue("photoID", photoID)
+	r = r.WithContext(auth.WithClaims(r.Context(), &auth.Claims{UserID: userID}))
+	w := httptest.NewRecorder()
+	h.Photo(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func profilePic(t *testing.T, h *Handler, userID string) string {
+	t.Helper()
+	user, err := h.store.Users.GetByID(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ProfilePic
+}
+
+func TestUploadedPhotoBecomesProfilePic(t *testing.T) {
+	h := newTestHandler(t, db.User{ID: "ada"})
+
+	photos := uploadPhoto(t, h, "ada")
+	if got := profilePic(t, h, "ada"); got != photos[0].URL {
+		t.Fatalf("profile pic after upload = %q, want %q", got, photos[0].URL)
+	}
+
+	deletePhoto(t, h, "ada", photos[0].ID)
+	if got := profilePic(t, h, "ada"); got != "" {
+		t.Errorf("profile pic after deleting the last upload = %q, want empty", got)
+	}
+}
+
+func TestProviderProfilePicSurvivesPhotoChanges(t *testing.T) {
+	const googlePic = "https://lh3.googleusercontent.com/a/ada.jpg"
+	h := newTestHandler(t, db.User{ID: "ada", ProfilePic: googlePic})
+
+	photos := uploadPhoto(t, h, "ada")
+	if got := profilePic(t, h, "ada"); got != googlePic {
+		t.Errorf("profile pic after upload = %q, want %q", got, googlePic)
+	}
+
+	deletePhoto(t, h, "ada", photos[0].ID)
+	if got := profilePic(t, h, "ada"); got != googlePic {
+		t.Errorf("profile pic after deleting the last upload = %q, want %q", got, googlePic)
+	}
+}
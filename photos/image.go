@@ -0,0 +1,133 @@
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Image limits and output sizes
+const (
+	minDimension = 320
+	maxDimension = 8000
+	// maxPixels bounds the decoded size so a small file cannot expand into gigabytes
+	maxPixels = 40_000_000
+
+	thumbnailSize = 320  // square crop for cards and lists
+	mediumSize    = 1080 // longest edge for the full-screen view
+	jpegQuality   = 85
+)
+
+// allowedTypes are the sniffed content types accepted for upload
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+var errUnsupportedType = errors.New("photo must be a JPEG, PNG or WebP image")
+
+// processed is an upload re-encoded as JPEG in every served size
+type processed struct {
+	thumbnail []byte
+	medium    []byte
+	width     int
+	height    int
+}
+
+// processImage validates an upload and renders its thumbnail and medium sizes.
+// The content type is sniffed from the bytes rather than trusted from the client,
+// and every output is re-encoded from pixels, which drops EXIF (including GPS
+// position) and any other embedded metadata.
+func processImage(data []byte) (*processed, error) {
+	if !allowedTypes[http.DetectContentType(data)] {
+		return nil, errUnsupportedType
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnsupportedType
+	}
+	if cfg.Width < minDimension || cfg.Height < minDimension {
+		return nil, fmt.Errorf("photo must be at least %dx%d pixels", minDimension, minDimension)
+	}
+	if cfg.Width > maxDimension || cfg.Height > maxDimension || cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("photo must be at most %dx%d pixels", maxDimension, maxDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("photo could not be decoded")
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+	img = flatten(img)
+
+	medium := fit(img, mediumSize)
+	thumbnail := cropSquare(img, thumbnailSize)
+
+	out := &processed{width: medium.Bounds().Dx(), height: medium.Bounds().Dy()}
+	if out.medium, err = encodeJPEG(medium); err != nil {
+		return nil, err
+	}
+	if out.thumbnail, err = encodeJPEG(thumbnail); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// fit scales img down so its longest edge is at most size, keeping the aspect ratio
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		h = h * size / w
+		w = size
+	} else {
+		w = w * size / h
+		h = size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(w, 1), max(h, 1)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// cropSquare takes the centred square of img and scales it to size x size
+func cropSquare(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, image.Rect(x, y, x+side, y+side), draw.Src, nil)
+	return dst
+}
+
+// flatten composites img onto white, since JPEG has no alpha channel and
+// transparent PNG areas would otherwise turn black
+func flatten(img image.Image) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,185 @@
+package photos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// halves returns a w x h image whose left half is red and right half blue
+func halves(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// exifSegment builds an APP1 segment holding a single orientation tag
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.BigEndian {
+		copy(tiff, "MM")
+	} else {
+		copy(tiff, "II")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112) // tag
+	order.PutUint16(tiff[12:], 3)      // SHORT
+	order.PutUint32(tiff[14:], 1)      // count
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// encodeJPEGWithOrientation encodes img and inserts an EXIF orientation after SOI
+func encodeJPEGWithOrientation(t *testing.T, img image.Image, order binary.ByteOrder, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	out = append(out, exifSegment(order, orientation)...)
+	return append(out, data[2:]...)
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	img := halves(16, 8)
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, orientation := range []uint16{1, 3, 6, 8} {
+			data := encodeJPEGWithOrientation(t, img, order, orientation)
+			if got := jpegOrientation(data); got != int(orientation) {
+				t.Errorf("%v orientation %d: got %d", order, orientation, got)
+			}
+		}
+	}
+
+	var plain bytes.Buffer
+	jpeg.Encode(&plain, img, nil)
+	if got := jpegOrientation(plain.Bytes()); got != 1 {
+		t.Errorf("JPEG without EXIF: got %d, want 1", got)
+	}
+	if got := jpegOrientation(encodeJPEGWithOrientation(t, img, binary.BigEndian, 9)); got != 1 {
+		t.Errorf("out of range orientation: got %d, want 1", got)
+	}
+	if got := jpegOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF}); got != 1 {
+		t.Errorf("truncated segment: got %d, want 1", got)
+	}
+}
+
+func TestProcessImageAppliesOrientation(t *testing.T) {
+	// Rotating 90 degrees clockwise turns the red left half into the top half
+	data := encodeJPEGWithOrientation(t, halves(400, 320), binary.BigEndian, 6)
+
+	out, err := processImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.width != 320 || out.height != 400 {
+		t.Fatalf("got %dx%d, want 320x400", out.width, out.height)
+	}
+
+	medium, err := jpeg.Decode(bytes.NewReader(out.medium))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, bottom := medium.At(160, 50), medium.At(160, 350)
+	if r, _, b, _ := top.RGBA(); r < b {
+		t.Errorf("top of rotated image is not red: %v", top)
+	}
+	if r, _, b, _ := bottom.RGBA(); b < r {
+		t.Errorf("bottom of rotated image is not blue: %v", bottom)
+	}
+	if bytes.Contains(out.medium, []byte("Exif")) || bytes.Contains(out.thumbnail, []byte("Exif")) {
+		t.Error("EXIF survived re-encoding")
+	}
+}
+
+func TestProcessImageSizes(t *testing.T) {
+	out, err := processImage(encodePNG(t, halves(2160, 1080)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.width != mediumSize || out.height != mediumSize/2 {
+		t.Errorf("medium is %dx%d, want %dx%d", out.width, out.height, mediumSize, mediumSize/2)
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(out.thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := thumb.Bounds(); b.Dx() != thumbnailSize || b.Dy() != thumbnailSize {
+		t.Errorf("thumbnail is %dx%d, want %dx%d", b.Dx(), b.Dy(), thumbnailSize, thumbnailSize)
+	}
+}
+
+// pngHeader returns a PNG whose header claims w x h. DecodeConfig only reads
+// the header, so the limits can be checked without allocating the pixels.
+func pngHeader(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	data := encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	// signature (8) + length (4) + "IHDR" (4), then width and height
+	binary.BigEndian.PutUint32(data[16:], w)
+	binary.BigEndian.PutUint32(data[20:], h)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestProcessImageLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		w, h uint32
+		want string
+	}{
+		{"too small", 319, 1000, "at least"},
+		{"too wide", maxDimension + 1, 400, "at most"},
+		{"too many pixels", 7000, 7000, "at most"},
+	}
+	for _, tt := range tests {
+		_, err := processImage(pngHeader(t, tt.w, tt.h))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestProcessImageSniffsContentType(t *testing.T) {
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+	html := []byte("<html><body><img src=x></body></html>")
+	// A JPEG signature followed by garbage sniffs as JPEG but cannot be decoded
+	fakeJPEG := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, bytes.Repeat([]byte{0}, 64)...)
+
+	for name, data := range map[string][]byte{"gif": gif, "html": html, "fake jpeg": fakeJPEG, "empty": nil} {
+		if _, err := processImage(data); !errors.Is(err, errUnsupportedType) {
+			t.Errorf("%s: got %v, want errUnsupportedType", name, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package profile defines the user representations returned by the API, so that
+// sensitive fields such as the password hash never leave the server
+package profile
+
+import (
+	"astromatch/db"
+	"math"
+	"time"
+)
+
+// Private is the owner's own view of their account
+type Private struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	Email            string       `json:"email,omitempty"`
+	Phone            string       `json:"phone,omitempty"`
+	Birthdate        string       `json:"birthdate,omitempty"`
+	Age              *int         `json:"age,omitempty"`
+	ZodiacSign       string       `json:"zodiacSign,omitempty"`
+	ProfilePic       string       `json:"profilePic,omitempty"`
+	Language         string       `json:"language,omitempty"`
+	Location         *db.GeoPoint `json:"location,omitempty"`
+	Photos           []db.Photo   `json:"photos"`
+	Bio              string       `json:"bio,omitempty"`
+	Gender           string       `json:"gender,omitempty"`
+	InterestedIn     []string     `json:"interestedIn"`
+	RelationshipGoal string       `json:"relationshipGoal,omitempty"`
+	HeightCm         int          `json:"heightCm,omitempty"`
+	Languages        []string     `json:"languages"`
+	Prompts          []db.Prompt  `json:"prompts"`
+	SignupMethod     string       `json:"signupMethod"`
+	IsVerified       bool         `json:"isVerified"`
+	MFAEnabled       bool         `json:"mfaEnabled"`
+	Version          int64        `json:"version"`
+}
+
+// Public is the card other users see
+type Public struct {
+	ID               string      `json:"id"`
+	Name             string      `json:"name"`
+	Age              *int        `json:"age,omitempty"`
+	ZodiacSign       string      `json:"zodiacSign,omitempty"`
+	Photos           []string    `json:"photos"`
+	Bio              string      `json:"bio,omitempty"`
+	Gender           string      `json:"gender,omitempty"`
+	RelationshipGoal string      `json:"relationshipGoal,omitempty"`
+	HeightCm         int         `json:"heightCm,omitempty"`
+	Languages        []string    `json:"languages"`
+	Prompts          []db.Prompt `json:"prompts"`
+	Interests        []string    `json:"interests"`
+	DistanceKm       *float64    `json:"distanceKm,omitempty"`
+}
+
+// Admin is the moderation view: everything except credentials
+type Admin struct {
+	Private
+	Role      string     `json:"role,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// NewPrivate builds the owner's view of user
+func NewPrivate(user db.User) Private {
+	return Private{
+		ID:               user.ID,
+		Name:             user.Name,
+		Email:            user.Email,
+		Phone:            user.Phone,
+		Birthdate:        user.Birthdate,
+		Age:              Age(user.Birthdate, time.Now()),
+		ZodiacSign:       user.ZodiacSign,
+		ProfilePic:       user.ProfilePic,
+		Language:         user.Language,
+		Location:         user.Location,
+		Photos:           nonNilPhotos(user.Photos),
+		Bio:              user.Bio,
+		Gender:           user.Gender,
+		InterestedIn:     nonNil(user.InterestedIn),
+		RelationshipGoal: user.RelationshipGoal,
+		HeightCm:         user.HeightCm,
+		Languages:        nonNil(user.Languages),
+		Prompts:          nonNilPrompts(user.Prompts),
+		SignupMethod:     user.SignupMethod,
+		IsVerified:       user.IsVerified,
+		MFAEnabled:       user.MFA != nil && user.MFA.Enabled,
+		Version:          user.Version,
+	}
+}
+
+// NewPublic builds the card of user as seen by viewer. prefs may be nil.
+func NewPublic(user db.User, prefs *db.UserPreferences, viewer db.User) Public {
+	card := Public{
+		ID:               user.ID,
+		Name:             user.Name,
+		Age:              Age(user.Birthdate, time.Now()),
+		ZodiacSign:       user.ZodiacSign,
+		Photos:           photoURLs(user),
+		Bio:              user.Bio,
+		Gender:           user.Gender,
+		RelationshipGoal: user.RelationshipGoal,
+		HeightCm:         user.HeightCm,
+		Languages:        nonNil(user.Languages),
+		Prompts:          nonNilPrompts(user.Prompts),
+		Interests:        []string{},
+		DistanceKm:       DistanceKm(viewer.Location, user.Location),
+	}
+	if prefs != nil && prefs.Interests != nil {
+		card.Interests = prefs.Interests
+	}
+	return card
+}
+
+// NewAdmin builds the moderation view of user
+func NewAdmin(user db.User) Admin {
+	return Admin{Private: NewPrivate(user), Role: user.Role, DeletedAt: user.DeletedAt}
+}
+
+// nonNil keeps empty lists encoded as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func nonNilPrompts(prompts []db.Prompt) []db.Prompt {
+	if prompts == nil {
+		return []db.Prompt{}
+	}
+	return prompts
+}
+
+func nonNilPhotos(photos []db.Photo) []db.Photo {
+	if photos == nil {
+		return []db.Photo{}
+	}
+	return photos
+}
+
+// photoURLs lists the uploaded photos in order, falling back to the external
+// profile picture (e.g. from Google) for users who have not uploaded any
+func photoURLs(user db.User) []string {
+	urls := make([]string, 0, len(user.Photos))
+	for _, photo := range user.Photos {
+		urls = append(urls, photo.URL)
+	}
+	if len(urls) == 0 && user.ProfilePic != "" {
+		urls = append(urls, user.ProfilePic)
+	}
+	return urls
+}
+
+// Age returns the age in whole years at now for a YYYY-MM-DD birthdate, or nil
+func Age(birthdate string, now time.Time) *int {
+	born, err := time.Parse("2006-01-02", birthdate)
+	if err != nil {
+		return nil
+	}
+	age := now.Year() - born.Year()
+	if now.Month() < born.Month() || (now.Month() == born.Month() && now.Day() < born.Day()) {
+		age--
+	}
+	return &age
+}
+
+// DistanceKm returns the great-circle distance between two points rounded to
+// whole kilometres, or nil when either is unknown
+func DistanceKm(a, b *db.GeoPoint) *float64 {
+	if a == nil || b == nil {
+		return nil
+	}
+	const earthRadiusKm = 6371.0
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	km := math.Round(2 * earthRadiusKm * math.Asin(math.Sqrt(h)))
+	return &km
+}
@@ -0,0 +1,310 @@
+// Package ratelimit throttles requests with sliding windows kept in Redis, so
+// limits hold across instances, falling back to per-instance memory while Redis
+// is unreachable. It also locks accounts out after repeated failed logins.
+package ratelimit
+
+import (
+	"astromatch/cache"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule allows Limit requests per sliding Window. In a route override a zero
+// Limit keeps the default; a negative one removes the limit.
+type Rule struct {
+	Limit  int           `yaml:"limit" json:"limit"`
+	Window time.Duration `yaml:"window" json:"window"`
+}
+
+// RouteLimits overrides the default limits for one route
+type RouteLimits struct {
+	PerIP         Rule `yaml:"perIp" json:"perIp"`
+	PerIdentifier Rule `yaml:"perIdentifier" json:"perIdentifier"`
+}
+
+// Config holds rate limit and lockout settings
+type Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled" env:"RATE_LIMIT_ENABLED"`
+	// TrustProxy takes the client IP from the X-Forwarded-For entry added by our
+	// reverse proxy; leave it off when clients connect directly
+	TrustProxy bool `yaml:"trustProxy" json:"trustProxy" env:"RATE_LIMIT_TRUST_PROXY"`
+	// IPLimit requests per IPWindow are allowed from one IP on each limited route
+	IPLimit  int           `yaml:"ipLimit" json:"ipLimit" env:"RATE_LIMIT_IP_LIMIT"`
+	IPWindow time.Duration `yaml:"ipWindow" json:"ipWindow" env:"RATE_LIMIT_IP_WINDOW"`
+	// IdentifierLimit requests per IdentifierWindow are allowed for one email or
+	// phone number on each limited route
+	IdentifierLimit  int           `yaml:"identifierLimit" json:"identifierLimit" env:"RATE_LIMIT_IDENTIFIER_LIMIT"`
+	IdentifierWindow time.Duration `yaml:"identifierWindow" json:"identifierWindow" env:"RATE_LIMIT_IDENTIFIER_WINDOW"`
+	// Routes overrides the limits per route name, configured in the config file only
+	Routes map[string]RouteLimits `yaml:"routes" json:"routes"`
+	// LockoutThreshold failed logins within LockoutWindow lock the account for
+	// LockoutDuration, doubling with every further failure up to LockoutMaxDuration
+	LockoutThreshold   int           `yaml:"lockoutThreshold" json:"lockoutThreshold" env:"LOCKOUT_THRESHOLD"`
+	LockoutWindow      time.Duration `yaml:"lockoutWindow" json:"lockoutWindow" env:"LOCKOUT_WINDOW"`
+	LockoutDuration    time.Duration `yaml:"lockoutDuration" json:"lockoutDuration" env:"LOCKOUT_DURATION"`
+	LockoutMaxDuration time.Duration `yaml:"lockoutMaxDuration" json:"lockoutMaxDuration" env:"LOCKOUT_MAX_DURATION"`
+}
+
+// Names of the limited routes, as used in Config.Routes
+const (
+	RouteLogin              = "login"
+	RouteSignup             = "signup"
+	RouteVerifyOTP          = "verify-otp"
+	RouteResendVerification = "resend-verification"
+	RouteMFA                = "mfa"
+	RoutePasskeyLogin       = "passkey-login"
+)
+
+// Active settings, set by Init; limiting is off until then
+var (
+	settings Config
+	windows  store = &fallbackStore{memory: newMemoryStore()}
+	// now is replaced in tests
+	now = time.Now
+)
+
+// Init applies cfg
+func Init(cfg Config) {
+	settings = cfg
+}
+
+// Enabled reports whether requests are limited
+func Enabled() bool { return settings.Enabled }
+
+// TrustProxy reports whether client IPs come from X-Forwarded-For
+func TrustProxy() bool { return settings.TrustProxy }
+
+// Limits returns the per IP and per identifier rules for route
+func Limits(route string) (perIP, perIdentifier Rule) {
+	perIP = Rule{Limit: settings.IPLimit, Window: settings.IPWindow}
+	perIdentifier = Rule{Limit: settings.IdentifierLimit, Window: settings.IdentifierWindow}
+	if override, ok := settings.Routes[route]; ok {
+		perIP = merge(perIP, override.PerIP)
+		perIdentifier = merge(perIdentifier, override.PerIdentifier)
+	}
+	return perIP, perIdentifier
+}
+
+func merge(base, override Rule) Rule {
+	if override.Limit != 0 {
+		base.Limit = override.Limit
+	}
+	if override.Window > 0 {
+		base.Window = override.Window
+	}
+	return base
+}
+
+// Allow counts a request against rule under key. When the limit is reached it
+// returns false and how long until the oldest counted request leaves the window.
+func Allow(ctx context.Context, key string, rule Rule) (bool, time.Duration) {
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return true, 0
+	}
+	t := now()
+	ok, events := windows.take(ctx, "ratelimit:"+key, rule.Limit, rule.Window, t)
+	if ok || len(events) < rule.Limit {
+		return true, 0
+	}
+	// Room frees up when the oldest of the last Limit requests leaves the window
+	return false, retryAfter(events[len(events)-rule.Limit].Add(rule.Window), t)
+}
+
+// IPKey is the Allow key for requests to route from ip
+func IPKey(route, ip string) string {
+	return route + ":ip:" + ip
+}
+
+// IdentifierKey is the Allow key for requests to route naming an email, phone
+// number or user. Identifiers are hashed so they are not stored in Redis.
+func IdentifierKey(route, identifier string) string {
+	return route + ":id:" + hashIdentifier(identifier)
+}
+
+func hashIdentifier(identifier string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(identifier))))
+	return hex.EncodeToString(sum[:16])
+}
+
+// LockedFor returns how much longer logins for identifier are locked out. Callers
+// pass the account's user ID when it is known, so every way of naming an account
+// shares one budget.
+func LockedFor(ctx context.Context, identifier string) time.Duration {
+	if settings.LockoutThreshold <= 0 {
+		return 0
+	}
+	t := now()
+	_, failures := windows.take(ctx, lockoutKey(identifier), 0, settings.LockoutWindow, t)
+	return lockoutRemaining(failures, t)
+}
+
+// RecordFailure counts a failed login for identifier and returns the lockout it
+// triggers, if any
+func RecordFailure(ctx context.Context, identifier string) time.Duration {
+	if settings.LockoutThreshold <= 0 {
+		return 0
+	}
+	t := now()
+	// Failures past the cap no longer lengthen the lockout, so stop recording them
+	capacity := settings.LockoutThreshold + maxDoublings + 1
+	_, failures := windows.take(ctx, lockoutKey(identifier), capacity, settings.LockoutWindow, t)
+	return lockoutRemaining(failures, t)
+}
+
+// ResetFailures forgets failed logins for identifier after a successful one
+func ResetFailures(ctx context.Context, identifier string) {
+	if settings.LockoutThreshold > 0 {
+		windows.reset(ctx, lockoutKey(identifier))
+	}
+}
+
+func lockoutKey(identifier string) string {
+	return "lockout:login:" + hashIdentifier(identifier)
+}
+
+// maxDoublings bounds the lockout growth so the shift cannot overflow
+const maxDoublings = 16
+
+// lockoutRemaining is the lockout left after failures: none below the
+// threshold, then LockoutDuration from the latest failure, doubled for each
+// failure beyond the threshold and capped at LockoutMaxDuration
+func lockoutRemaining(failures []time.Time, t time.Time) time.Duration {
+	if len(failures) < settings.LockoutThreshold {
+		return 0
+	}
+	doublings := len(failures) - settings.LockoutThreshold
+	if doublings > maxDoublings {
+		doublings = maxDoublings
+	}
+	d := settings.LockoutDuration << doublings
+	if settings.LockoutMaxDuration > 0 && d > settings.LockoutMaxDuration {
+		d = settings.LockoutMaxDuration
+	}
+	return retryAfter(failures[len(failures)-1].Add(d), t)
+}
+
+func retryAfter(until, t time.Time) time.Duration {
+	if d := until.Sub(t); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// store keeps sliding window logs of event times
+type store interface {
+	// take records an event at t under key if fewer than limit events fall within
+	// window, returning whether it did and the events in the window, oldest first
+	take(ctx context.Context, key string, limit int, window time.Duration, t time.Time) (bool, []time.Time)
+	reset(ctx context.Context, key string)
+}
+
+// fallbackStore uses Redis and switches to memory while Redis fails, so an
+// outage weakens limits to per instance instead of lifting them
+type fallbackStore struct {
+	memory *memoryStore
+
+	mu       sync.Mutex
+	degraded bool
+}
+
+func (s *fallbackStore) take(ctx context.Context, key string, limit int, window time.Duration, t time.Time) (bool, []time.Time) {
+	ok, events, err := cache.TakeSlot(ctx, key, limit, window, t)
+	if s.healthy(err) {
+		return ok, events
+	}
+	return s.memory.take(ctx, key, limit, window, t)
+}
+
+func (s *fallbackStore) reset(ctx context.Context, key string) {
+	err := cache.Delete(ctx, key)
+	s.healthy(err)
+	// Memory may hold events recorded during an outage
+	s.memory.reset(ctx, key)
+}
+
+// healthy records the outcome of a Redis call, logging only when it changes
+func (s *fallbackStore) healthy(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && !s.degraded:
+		log.Printf("Rate limiting falls back to memory: %v", err)
+	case err == nil && s.degraded:
+		log.Printf("Rate limiting back on Redis")
+	}
+	s.degraded = err != nil
+	return err == nil
+}
+
+// memoryStore is a per-instance store
+type memoryStore struct {
+	mu        sync.Mutex
+	logs      map[string]*eventLog
+	lastSweep time.Time
+}
+
+type eventLog struct {
+	events  []time.Time
+	expires time.Time
+}
+
+// sweepInterval is how often expired logs are dropped
+const sweepInterval = time.Minute
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{logs: make(map[string]*eventLog)}
+}
+
+func (s *memoryStore) take(_ context.Context, key string, limit int, window time.Duration, t time.Time) (bool, []time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(t)
+
+	l := s.logs[key]
+	if l == nil {
+		l = &eventLog{}
+	}
+	cutoff := t.Add(-window)
+	kept := l.events[:0]
+	for _, e := range l.events {
+		if e.After(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	l.events = kept
+
+	added := len(l.events) < limit
+	if added {
+		l.events = append(l.events, t)
+	}
+	if len(l.events) == 0 {
+		delete(s.logs, key)
+		return false, nil
+	}
+	l.expires = t.Add(window)
+	s.logs[key] = l
+	return added, append([]time.Time(nil), l.events...)
+}
+
+func (s *memoryStore) reset(_ context.Context, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.logs, key)
+}
+
+func (s *memoryStore) sweep(t time.Time) {
+	if t.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = t
+	for key, l := range s.logs {
+		if !l.expires.After(t) {
+			delete(s.logs, key)
+		}
+	}
+}
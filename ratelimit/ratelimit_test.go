@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// useSettings applies cfg, a fresh memory store and a clock the test moves by hand
+func useSettings(t *testing.T, cfg Config) *time.Time {
+	t.Helper()
+	oldSettings, oldWindows, oldNow := settings, windows, now
+	t.Cleanup(func() { settings, windows, now = oldSettings, oldWindows, oldNow })
+
+	clock := start
+	settings = cfg
+	windows = &fallbackStore{memory: newMemoryStore()}
+	now = func() time.Time { return clock }
+	return &clock
+}
+
+func TestMemoryStoreSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	window := time.Minute
+
+	for i := 0; i < 3; i++ {
+		ok, events := s.take(ctx, "k", 3, window, start.Add(time.Duration(i)*10*time.Second))
+		if !ok || len(events) != i+1 {
+			t.Fatalf("take %d: ok %v, %d events", i, ok, len(events))
+		}
+	}
+	ok, events := s.take(ctx, "k", 3, window, start.Add(30*time.Second))
+	if ok || len(events) != 3 || !events[0].Equal(start) {
+		t.Fatalf("over the limit: ok %v, events %v", ok, events)
+	}
+
+	// The first event leaves the window a minute after it happened
+	ok, events = s.take(ctx, "k", 3, window, start.Add(window))
+	if !ok || len(events) != 3 || !events[0].Equal(start.Add(10*time.Second)) {
+		t.Errorf("after the oldest expired: ok %v, events %v", ok, events)
+	}
+
+	// A zero limit only reads
+	if ok, events := s.take(ctx, "k", 0, window, start.Add(window)); ok || len(events) != 3 {
+		t.Errorf("read: ok %v, %d events", ok, len(events))
+	}
+	if ok, events := s.take(ctx, "other", 0, window, start); ok || events != nil {
+		t.Errorf("read of an empty key: ok %v, events %v", ok, events)
+	}
+	if _, ok := s.logs["other"]; ok {
+		t.Error("reading an empty key stored a log")
+	}
+
+	s.reset(ctx, "k")
+	if _, events := s.take(ctx, "k", 0, window, start.Add(window)); len(events) != 0 {
+		t.Errorf("events after reset: %v", events)
+	}
+}
+
+func TestMemoryStoreSweepsExpiredLogs(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	s.take(ctx, "short", 5, time.Second, start)
+	s.take(ctx, "long", 5, time.Hour, start)
+
+	s.take(ctx, "other", 5, time.Hour, start.Add(2*sweepInterval))
+	if _, ok := s.logs["short"]; ok {
+		t.Error("expired log was not swept")
+	}
+	if _, ok := s.logs["long"]; !ok {
+		t.Error("live log was swept")
+	}
+}
+
+func TestLockoutRemaining(t *testing.T) {
+	useSettings(t, Config{LockoutThreshold: 3, LockoutDuration: time.Minute, LockoutMaxDuration: 10 * time.Minute})
+
+	failures := func(n int) []time.Time {
+		events := make([]time.Time, n)
+		for i := range events {
+			events[i] = start.Add(time.Duration(i) * time.Second)
+		}
+		return events
+	}
+	latest := func(n int) time.Time { return start.Add(time.Duration(n-1) * time.Second) }
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute}, // capped
+		{40, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := lockoutRemaining(failures(tt.failures), latest(tt.failures)); got != tt.want {
+			t.Errorf("%d failures: got %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// The lockout runs from the latest failure
+	if got := lockoutRemaining(failures(3), latest(3).Add(45*time.Second)); got != 15*time.Second {
+		t.Errorf("45s into a 1m lockout: got %s", got)
+	}
+	if got := lockoutRemaining(failures(3), latest(3).Add(2*time.Minute)); got != 0 {
+		t.Errorf("after the lockout: got %s", got)
+	}
+}
+
+func TestLockoutFallsBackToMemoryWithoutRedis(t *testing.T) {
+	clock := useSettings(t, Config{
+		LockoutThreshold: 2,
+		LockoutWindow:    time.Hour,
+		LockoutDuration:  time.Minute,
+	})
+	ctx := context.Background()
+
+	// Redis is not initialised in tests, so every call takes the memory path
+	if wait := RecordFailure(ctx, "user:ada"); wait != 0 {
+		t.Fatalf("locked after one failure: %s", wait)
+	}
+	if wait := RecordFailure(ctx, "user:ada"); wait != time.Minute {
+		t.Fatalf("second failure: locked for %s, want 1m", wait)
+	}
+	if !windows.(*fallbackStore).degraded {
+		t.Error("store did not record that Redis is down")
+	}
+
+	*clock = clock.Add(20 * time.Second)
+	if wait := LockedFor(ctx, "USER:ada "); wait != 40*time.Second {
+		t.Errorf("identifier is not normalised or clock ignored: locked for %s", wait)
+	}
+	if wait := LockedFor(ctx, "user:bob"); wait != 0 {
+		t.Errorf("another account is locked for %s", wait)
+	}
+
+	ResetFailures(ctx, "user:ada")
+	if wait := LockedFor(ctx, "user:ada"); wait != 0 {
+		t.Errorf("locked for %s after a reset", wait)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	clock := useSettings(t, Config{})
+	ctx := context.Background()
+	rule := Rule{Limit: 2, Window: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := Allow(ctx, "login:ip:1.2.3.4", rule); !ok {
+			t.Fatalf("request %d refused", i)
+		}
+		*clock = clock.Add(10 * time.Second)
+	}
+	ok, wait := Allow(ctx, "login:ip:1.2.3.4", rule)
+	if ok || wait != 40*time.Second {
+		t.Errorf("third request: ok %v, retry after %s, want 40s", ok, wait)
+	}
+	if ok, _ := Allow(ctx, "login:ip:5.6.7.8", rule); !ok {
+		t.Error("another IP was refused")
+	}
+	if ok, _ := Allow(ctx, "login:ip:1.2.3.4", Rule{Limit: -1, Window: time.Minute}); !ok {
+		t.Error("a negative limit still limited")
+	}
+}
+
+func TestLimitsMergeRouteOverrides(t *testing.T) {
+	useSettings(t, Config{
+		IPLimit: 100, IPWindow: time.Minute,
+		IdentifierLimit: 10, IdentifierWindow: time.Hour,
+		Routes: map[string]RouteLimits{
+			RouteLogin:  {PerIP: Rule{Limit: 20}, PerIdentifier: Rule{Window: 15 * time.Minute}},
+			RouteSignup: {PerIdentifier: Rule{Limit: -1}},
+		},
+	})
+
+	tests := []struct {
+		route             string
+		perIP, identifier Rule
+	}{
+		{RouteLogin, Rule{20, time.Minute}, Rule{10, 15 * time.Minute}},
+		{RouteSignup, Rule{100, time.Minute}, Rule{-1, time.Hour}},
+		{RouteMFA, Rule{100, time.Minute}, Rule{10, time.Hour}},
+	}
+	for _, tt := range tests {
+		perIP, perIdentifier := Limits(tt.route)
+		if perIP != tt.perIP || perIdentifier != tt.identifier {
+			t.Errorf("%s: got %+v %+v, want %+v %+v", tt.route, perIP, perIdentifier, tt.perIP, tt.identifier)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package respond writes the JSON responses and error envelope shared by every handler package
+package respond
+
+import (
+	"astromatch/db"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Machine-readable error codes
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidToken         = "invalid_token"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInvalidOTP           = "invalid_otp"
+	CodeInvalidMFACode       = "invalid_mfa_code"
+	CodeForbidden            = "forbidden"
+	CodeVerificationRequired = "verification_required"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeTooManyPhotos        = "too_many_photos"
+	CodeRateLimited          = "rate_limited"
+	CodeAccountLocked        = "account_locked"
+	CodeUpstreamFailed       = "upstream_failed"
+	CodeTimeout              = "timeout"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// ErrorBody is the body of every error response
+type ErrorBody struct {
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	Details   []db.FieldError `json:"details,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+}
+
+// Envelope wraps ErrorBody as {"error": {...}}
+type Envelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+type requestIDKey struct{}
+
+// WithRequestID stores the request ID in ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// JSON writes v with the given status
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Error writes an error envelope
+func Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeError(w, r, status, ErrorBody{Code: code, Message: message})
+}
+
+// Validation writes a 400 listing every invalid field
+func Validation(w http.ResponseWriter, r *http.Request, err *db.ValidationError) {
+	writeError(w, r, http.StatusBadRequest, ErrorBody{
+		Code:    CodeValidationFailed,
+		Message: "Some fields are invalid",
+		Details: err.Fields,
+	})
+}
+
+// DBError writes the response for a failed repository call. Not found, conflict,
+// precondition and validation errors map to 404, 409, 412 and 400; timeouts to 504; an unreachable
+// database to 503; anything else to 500 with message.
+func DBError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var validation *db.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		Validation(w, r, validation)
+	case errors.Is(err, db.ErrNotFound):
+		Error(w, r, http.StatusNotFound, CodeNotFound, capitalize(err.Error()))
+	case errors.Is(err, db.ErrConflict):
+		Error(w, r, http.StatusConflict, CodeConflict, capitalize(err.Error()))
+	case errors.Is(err, db.ErrPrecondition):
+		Error(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, capitalize(err.Error()))
+	case db.IsTimeout(err):
+		log.Printf("[%s] Database timeout: %v", RequestID(r.Context()), err)
+		Error(w, r, http.StatusGatewayTimeout, CodeTimeout, "Database timed out")
+	case db.IsUnavailable(err):
+		log.Printf("[%s] Database unavailable: %v", RequestID(r.Context()), err)
+		Error(w, r, http.StatusServiceUnavailable, CodeUnavailable, "Database unavailable")
+	default:
+		log.Printf("[%s] %s: %v", RequestID(r.Context()), message, err)
+		Error(w, r, http.StatusInternalServerError, CodeInternal, message)
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, body ErrorBody) {
+	body.RequestID = RequestID(r.Context())
+	JSON(w, status, Envelope{Error: body})
+}
+
+func capitalize(s string) string {
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
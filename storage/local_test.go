@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalHandlerRefusesPrivateKeys(t *testing.T) {
+	s := NewLocalStore(t.TempDir(), "/media")
+	ctx := context.Background()
+	for _, key := range []string{"users/u1/photos/p1/medium.jpg", PrivatePrefix + "exports/u1.zip"} {
+		if err := s.Put(ctx, key, []byte("data"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := http.StripPrefix("/media/", s.Handler())
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/media/users/u1/photos/p1/medium.jpg", http.StatusOK},
+		{"/media/private/exports/u1.zip", http.StatusNotFound},
+		{"/media/users/../private/exports/u1.zip", http.StatusNotFound},
+		{"/media/./private/exports/u1.zip", http.StatusNotFound},
+		{"/media/users/u1/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = tt.path // bypass the client-side cleaning NewRequest would apply
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET %s: status %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package storage keeps uploaded files such as profile photos in a pluggable blob store
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// ErrNotFound is returned when a key does not exist in the store
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque objects under slash-separated keys
+type BlobStore interface {
+	// Put stores data under key, replacing any existing object
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open returns the object stored under key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// URL is the address clients use to fetch key
+	URL(key string) string
+}
+
+// Config selects and configures the blob store
+type Config struct {
+	Provider string `yaml:"provider" json:"provider" env:"STORAGE_PROVIDER"` // "local" or "s3"
+
+	// PublicURL prefixes object keys in URLs handed to clients
+	PublicURL string `yaml:"publicUrl" json:"publicUrl" env:"STORAGE_PUBLIC_URL"`
+
+	// LocalDir is the root directory of the local store
+	LocalDir string `yaml:"localDir" json:"localDir" env:"STORAGE_LOCAL_DIR"`
+
+	S3Endpoint  string `yaml:"s3Endpoint" json:"s3Endpoint" env:"S3_ENDPOINT"`
+	S3Region    string `yaml:"s3Region" json:"s3Region" env:"S3_REGION"`
+	S3Bucket    string `yaml:"s3Bucket" json:"s3Bucket" env:"S3_BUCKET"`
+	S3AccessKey string `yaml:"s3AccessKey" json:"s3AccessKey" env:"S3_ACCESS_KEY_ID"`
+	S3SecretKey string `yaml:"s3SecretKey" json:"s3SecretKey" env:"S3_SECRET_ACCESS_KEY"`
+	// S3PathStyle addresses the bucket as endpoint/bucket (MinIO) instead of bucket.endpoint
+	S3PathStyle bool `yaml:"s3PathStyle" json:"s3PathStyle" env:"S3_PATH_STYLE"`
+}
+
+// PrivatePrefix starts keys that must never be served publicly, such as data
+// exports. They are only read back through authenticated endpoints; S3 bucket
+// policies should grant public reads on other keys only.
+const PrivatePrefix = "private/"
+
+// Blobs is the active store, set by Init
+var Blobs BlobStore
+
+// Init selects the blob store described by cfg
+func Init(cfg Config) error {
+	store, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	Blobs = store
+	log.Printf("Storage: blobs via %s", cfg.Provider)
+	return nil
+}
+
+// New builds the blob store named by cfg.Provider
+func New(cfg Config) (BlobStore, error) {
+	switch cfg.Provider {
+	case "local":
+		return NewLocalStore(cfg.LocalDir, cfg.PublicURL), nil
+	case "s3":
+		return NewS3Store(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+			PublicURL: cfg.PublicURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage provider %q", cfg.Provider)
+	}
+}
+
+// validKey rejects empty keys and keys that could escape the store's namespace
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("storage: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+// joinURL appends key to base with exactly one slash between them
+func joinURL(base, key string) string {
+	return strings.TrimRight(base, "/") + "/" + key
+}
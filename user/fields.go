@@ -0,0 +1,336 @@
+package user
+
+import (
+	"astromatch/db"
+	"astromatch/matchmaking"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// editableField describes a profile field users may change themselves
+type editableField struct {
+	bsonName string
+	required bool
+	validate func(value string) error
+	// decode replaces validate for fields whose JSON value is not a string
+	decode func(raw json.RawMessage) (interface{}, error)
+}
+
+// editableFields is the allow-list of user-editable profile fields, keyed by JSON name.
+// Anything else (email, phone, password, signupMethod, isVerified, ...) is rejected.
+// profilePic is not listed: it follows the photos uploaded through the photo API.
+var editableFields = map[string]editableField{
+	"name":       {bsonName: "name", required: true, validate: validateName},
+	"birthdate":  {bsonName: "birthdate", validate: validateBirthdate},
+	"zodiacSign": {bsonName: db.FieldZodiacSign, validate: validateZodiacSign},
+	"language":   {bsonName: "language", validate: validateLanguage},
+	"location":   {bsonName: "location", decode: decodeLocation},
+
+	"bio":              {bsonName: "bio", validate: validateBio},
+	"gender":           {bsonName: "gender", validate: validateGender},
+	"interestedIn":     {bsonName: "interested_in", decode: decodeInterestedIn},
+	"relationshipGoal": {bsonName: "relationship_goal", validate: validateRelationshipGoal},
+	"heightCm":         {bsonName: "height_cm", decode: decodeHeight},
+	"languages":        {bsonName: "languages", decode: decodeLanguages},
+	"prompts":          {bsonName: "prompts", decode: decodePrompts},
+}
+
+// Profile content limits
+const (
+	maxBioLength      = 500
+	maxLanguages      = 5
+	maxPrompts        = 3
+	maxQuestionLength = 100
+	maxAnswerLength   = 250
+	minHeightCm       = 90
+	maxHeightCm       = 250
+)
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]{2,4})?$`)
+
+func validateName(value string) error {
+	value = strings.TrimSpace(value)
+	if n := utf8.RuneCountInString(value); n < 1 || n > 50 {
+		return fmt.Errorf("must be between 1 and 50 characters")
+	}
+	return nil
+}
+
+func validateBirthdate(value string) error {
+	birthdate, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return fmt.Errorf("must be a date in YYYY-MM-DD format")
+	}
+	if birthdate.AddDate(18, 0, 0).After(time.Now()) {
+		return fmt.Errorf("you must be at least 18 years old")
+	}
+	if birthdate.Year() < 1900 {
+		return fmt.Errorf("must be after 1900")
+	}
+	return nil
+}
+
+func validateZodiacSign(value string) error {
+	if _, ok := matchmaking.CompatibilityMatrix[value]; !ok {
+		return fmt.Errorf("must be one of the 12 zodiac signs")
+	}
+	return nil
+}
+
+func validateLanguage(value string) error {
+	if !languagePattern.MatchString(value) {
+		return fmt.Errorf("must be a language tag such as en or es-MX")
+	}
+	return nil
+}
+
+func validateBio(value string) error {
+	if utf8.RuneCountInString(value) > maxBioLength {
+		return fmt.Errorf("must be at most %d characters", maxBioLength)
+	}
+	return nil
+}
+
+func validateGender(value string) error {
+	if !db.Genders[value] {
+		return fmt.Errorf("must be one of %s", strings.Join(sortedKeys(db.Genders), ", "))
+	}
+	return nil
+}
+
+func validateRelationshipGoal(value string) error {
+	if !db.RelationshipGoals[value] {
+		return fmt.Errorf("must be one of %s", strings.Join(sortedKeys(db.RelationshipGoals), ", "))
+	}
+	return nil
+}
+
+func decodeInterestedIn(raw json.RawMessage) (interface{}, error) {
+	var genders []string
+	if err := json.Unmarshal(raw, &genders); err != nil {
+		return nil, fmt.Errorf("must be an array of genders")
+	}
+	seen := make(map[string]bool, len(genders))
+	for _, gender := range genders {
+		if err := validateGender(gender); err != nil {
+			return nil, err
+		}
+		if seen[gender] {
+			return nil, fmt.Errorf("must not repeat %s", gender)
+		}
+		seen[gender] = true
+	}
+	return genders, nil
+}
+
+func decodeHeight(raw json.RawMessage) (interface{}, error) {
+	var height int
+	if err := json.Unmarshal(raw, &height); err != nil {
+		return nil, fmt.Errorf("must be a whole number of centimetres")
+	}
+	if height < minHeightCm || height > maxHeightCm {
+		return nil, fmt.Errorf("must be between %d and %d", minHeightCm, maxHeightCm)
+	}
+	return height, nil
+}
+
+func decodeLanguages(raw json.RawMessage) (interface{}, error) {
+	var languages []string
+	if err := json.Unmarshal(raw, &languages); err != nil {
+		return nil, fmt.Errorf("must be an array of language tags")
+	}
+	if len(languages) > maxLanguages {
+		return nil, fmt.Errorf("must list at most %d languages", maxLanguages)
+	}
+	for _, language := range languages {
+		if err := validateLanguage(language); err != nil {
+			return nil, err
+		}
+	}
+	return languages, nil
+}
+
+func decodePrompts(raw json.RawMessage) (interface{}, error) {
+	var prompts []db.Prompt
+	if err := json.Unmarshal(raw, &prompts); err != nil {
+		return nil, fmt.Errorf("must be an array of {question, answer} objects")
+	}
+	if len(prompts) > maxPrompts {
+		return nil, fmt.Errorf("must have at most %d prompts", maxPrompts)
+	}
+	for i := range prompts {
+		prompts[i].Question = strings.TrimSpace(prompts[i].Question)
+		prompts[i].Answer = strings.TrimSpace(prompts[i].Answer)
+		if n := utf8.RuneCountInString(prompts[i].Question); n < 1 || n > maxQuestionLength {
+			return nil, fmt.Errorf("question %d must be between 1 and %d characters", i+1, maxQuestionLength)
+		}
+		if n := utf8.RuneCountInString(prompts[i].Answer); n < 1 || n > maxAnswerLength {
+			return nil, fmt.Errorf("answer %d must be between 1 and %d characters", i+1, maxAnswerLength)
+		}
+	}
+	return prompts, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeLocation requires both coordinates, after any patch has been merged into
+// the stored location, so a user without one must not end up with lng 0
+func decodeLocation(raw json.RawMessage) (interface{}, error) {
+	var coords struct {
+		Lat *float64 `json:"lat"`
+		Lng *float64 `json:"lng"`
+	}
+	if err := json.Unmarshal(raw, &coords); err != nil || coords.Lat == nil || coords.Lng == nil {
+		return nil, fmt.Errorf("must be an object with lat and lng")
+	}
+	point := db.GeoPoint{Lat: *coords.Lat, Lng: *coords.Lng}
+	if point.Lat < -90 || point.Lat > 90 || point.Lng < -180 || point.Lng > 180 {
+		return nil, fmt.Errorf("lat must be within ±90 and lng within ±180")
+	}
+	return point, nil
+}
+
+// parseProfileUpdate turns a JSON object into document fields to set (or unset, for
+// null values) after checking every key against the allow-list. When replace is true
+// (PUT), each key replaces the whole field and editable fields missing from the body
+// are unset; otherwise object values are merged into current's as a JSON Merge Patch.
+func parseProfileUpdate(raw map[string]json.RawMessage, replace bool, current db.User) (map[string]interface{}, error) {
+	var stored map[string]json.RawMessage
+	if !replace {
+		encoded, err := json.Marshal(current)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(encoded, &stored); err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	verr := &db.ValidationError{}
+	fields := make(map[string]interface{})
+	for _, name := range names {
+		value := raw[name]
+		field, ok := editableFields[name]
+		if !ok {
+			verr.Add(name, "field cannot be changed")
+			continue
+		}
+
+		if string(value) == "null" {
+			if field.required {
+				verr.Add(name, "cannot be removed")
+			} else {
+				fields[field.bsonName] = nil
+			}
+			continue
+		}
+
+		if !replace {
+			merged, err := mergePatch(stored[name], value)
+			if err != nil {
+				verr.Add(name, "must be valid JSON")
+				continue
+			}
+			value = merged
+		}
+
+		if field.decode != nil {
+			decoded, err := field.decode(value)
+			if err != nil {
+				verr.Add(name, err.Error())
+				continue
+			}
+			fields[field.bsonName] = decoded
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			verr.Add(name, "must be a string")
+			continue
+		}
+		if err := field.validate(s); err != nil {
+			verr.Add(name, err.Error())
+			continue
+		}
+		if name == "name" {
+			s = strings.TrimSpace(s)
+		}
+		fields[field.bsonName] = s
+	}
+
+	if replace {
+		for name, field := range editableFields {
+			if _, ok := raw[name]; ok {
+				continue
+			}
+			if field.required {
+				verr.Add(name, "is required")
+			} else {
+				fields[field.bsonName] = nil
+			}
+		}
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// mergePatch applies patch to target as described in RFC 7386: an object patch is
+// merged member by member, recursively, with null members removed; anything else
+// replaces target. A missing or non-object target counts as an empty object.
+func mergePatch(target, patch json.RawMessage) (json.RawMessage, error) {
+	var members map[string]json.RawMessage
+	if !isJSONObject(patch) {
+		return patch, nil
+	}
+	if err := json.Unmarshal(patch, &members); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]json.RawMessage)
+	if isJSONObject(target) {
+		if err := json.Unmarshal(target, &result); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range members {
+		if string(bytes.TrimSpace(value)) == "null" {
+			delete(result, name)
+			continue
+		}
+		merged, err := mergePatch(result[name], value)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = merged
+	}
+	return json.Marshal(result)
+}
+
+// isJSONObject reports whether raw holds a JSON object
+func isJSONObject(raw json.RawMessage) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
@@ -0,0 +1,15 @@
+package user
+
+import (
+	"astromatch/db"
+)
+
+// Handler serves profile and preference endpoints using the injected repositories
+type Handler struct {
+	store *db.Store
+}
+
+// NewHandler creates user handlers backed by store
+func NewHandler(store *db.Store) *Handler {
+	return &Handler{store: store}
+}
@@ -3,33 +3,33 @@ package user
 import (
 	"astromatch/auth"
 	"astromatch/db"
+	"astromatch/respond"
 	"encoding/json"
 	"net/http"
 )
 
-func UpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// UpdatePreferences (PUT) stores the authenticated user's matchmaking preferences
+func (h *Handler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
-
-	claims, err := auth.ValidateJWT(cookie.Value)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	var prefs db.UserPreferences
-	err = json.NewDecoder(r.Body).Decode(&prefs)
+	err := json.NewDecoder(r.Body).Decode(&prefs)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request payload")
 		return
 	}
 
-	err = db.UpdateUserPreferences(claims.UserID, prefs)
+	err = h.store.Preferences.Upsert(r.Context(), claims.UserID, prefs)
 	if err != nil {
-		http.Error(w, "Preferences update failed", http.StatusInternalServerError)
+		respond.DBError(w, r, err, "Preferences update failed")
 		return
 	}
 
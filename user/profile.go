@@ -1,67 +1,192 @@
 package user
 
 import (
+	"astromatch/auth"
 	"astromatch/db"
+	"astromatch/profile"
+	"astromatch/respond"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"mime"
 	"net/http"
-
-	"go.mongodb.org/mongo-driver/mongo"
+	"strconv"
+	"strings"
 )
 
-// GetOrUpdateProfile - Handles GET and PUT for user profiles
-func GetOrUpdateProfile(w http.ResponseWriter, r *http.Request) {
+// maxProfileBody caps profile update payloads
+const maxProfileBody = 64 << 10
+
+// maxUpdateAttempts bounds retries when a concurrent update bumps the version
+// between reading the profile and merging a patch into it
+const maxUpdateAttempts = 3
+
+// GetOrUpdateProfile - Handles GET, PUT and PATCH for user profiles.
+// GET accepts ?id= to read another user; updates always apply to the caller.
+func (h *Handler) GetOrUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		respond.Error(w, r, http.StatusUnauthorized, respond.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
 	userID := r.URL.Query().Get("id")
 	if userID == "" {
-		http.Error(w, "User ID is required", http.StatusBadRequest)
-		return
+		userID = claims.UserID
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		getUserProfile(w, userID)
-	case http.MethodPut:
-		updateUserProfile(w, r, userID)
+		h.getUserProfile(w, r, userID, claims.UserID)
+	case http.MethodPut, http.MethodPatch:
+		if userID != claims.UserID {
+			respond.Error(w, r, http.StatusForbidden, respond.CodeForbidden, "You can only update your own profile")
+			return
+		}
+		h.updateUserProfile(w, r, userID)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		respond.Error(w, r, http.StatusMethodNotAllowed, respond.CodeMethodNotAllowed, "Method not allowed")
 	}
 }
 
-// Fetch User Profile
-func getUserProfile(w http.ResponseWriter, userID string) {
-	user, err := db.GetUserByID(userID)
+// Fetch User Profile. Owners get the private view, admins the moderation view
+// and everyone else the public card.
+func (h *Handler) getUserProfile(w http.ResponseWriter, r *http.Request, userID, viewerID string) {
+	user, err := h.store.Users.GetByID(r.Context(), userID)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			http.Error(w, "User not found", http.StatusNotFound)
+		if errors.Is(err, db.ErrUserNotFound) {
+			respond.Error(w, r, http.StatusNotFound, respond.CodeNotFound, "User not found")
 		} else {
-			http.Error(w, "Failed to fetch user profile", http.StatusInternalServerError)
+			respond.DBError(w, r, err, "Failed to fetch user profile")
 		}
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+
+	if userID == viewerID {
+		w.Header().Set("ETag", etag(user.Version))
+		respond.JSON(w, http.StatusOK, profile.NewPrivate(user))
+		return
+	}
+
+	viewer, err := h.store.Users.GetByID(r.Context(), viewerID)
+	if err != nil {
+		respond.DBError(w, r, err, "Failed to fetch user profile")
+		return
+	}
+	if viewer.Role == db.RoleAdmin {
+		respond.JSON(w, http.StatusOK, profile.NewAdmin(user))
+		return
+	}
+	// Accounts awaiting deletion are hidden from everyone else
+	if user.DeletedAt != nil {
+		respond.Error(w, r, http.StatusNotFound, respond.CodeNotFound, "User not found")
+		return
+	}
+
+	var prefs *db.UserPreferences
+	if p, err := h.store.Preferences.Get(r.Context(), userID); err == nil {
+		prefs = &p
+	} else if !errors.Is(err, db.ErrNotFound) {
+		respond.DBError(w, r, err, "Failed to fetch user profile")
+		return
+	}
+	respond.JSON(w, http.StatusOK, profile.NewPublic(user, prefs, viewer))
 }
 
-// Update User Profile
-func updateUserProfile(w http.ResponseWriter, r *http.Request, userID string) {
-	var updatedUser db.User
-	err := json.NewDecoder(r.Body).Decode(&updatedUser)
+// Update User Profile. PATCH is a JSON Merge Patch (RFC 7386): fields present in
+// the body change, those set to null are removed and objects such as location are
+// merged member by member. PUT replaces every editable field. Both honour If-Match
+// against the profile's ETag.
+func (h *Handler) updateUserProfile(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method == http.MethodPatch {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+			respond.Error(w, r, http.StatusUnsupportedMediaType, respond.CodeInvalidRequest, "PATCH requires application/merge-patch+json")
+			return
+		}
+	}
+
+	expectedVersion, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid If-Match header")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProfileBody))
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		respond.Error(w, r, http.StatusBadRequest, respond.CodeInvalidRequest, "Invalid request payload")
 		return
 	}
 
-	err = db.UpdateUserProfile(userID, updatedUser)
+	user, err := h.applyProfileUpdate(r.Context(), userID, patch, r.Method == http.MethodPut, expectedVersion)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			http.Error(w, "User not found", http.StatusNotFound)
+		var verr *db.ValidationError
+		if errors.As(err, &verr) {
+			respond.Validation(w, r, verr)
 		} else {
-			http.Error(w, "Failed to update user profile", http.StatusInternalServerError)
+			respond.DBError(w, r, err, "Failed to update user profile")
 		}
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{"message": "User updated successfully"}
-	json.NewEncoder(w).Encode(response)
+	w.Header().Set("ETag", etag(user.Version))
+	respond.JSON(w, http.StatusOK, profile.NewPrivate(user))
+}
+
+// applyProfileUpdate merges patch into the stored profile and saves it. Without an
+// If-Match version the update is pinned to the version it was merged into and
+// retried when another request got in first, so no nested member is lost.
+func (h *Handler) applyProfileUpdate(ctx context.Context, userID string, patch map[string]json.RawMessage, replace bool, expectedVersion int64) (db.User, error) {
+	var err error
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		var current db.User
+		current, err = h.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			return db.User{}, err
+		}
+
+		fields, parseErr := parseProfileUpdate(patch, replace, current)
+		if parseErr != nil {
+			return db.User{}, parseErr
+		}
+
+		version := expectedVersion
+		if version == db.AnyVersion {
+			version = current.Version
+		}
+		var user db.User
+		user, err = h.store.Users.UpdateFields(ctx, userID, fields, version)
+		if !errors.Is(err, db.ErrVersionMismatch) || expectedVersion != db.AnyVersion {
+			return user, err
+		}
+	}
+	return db.User{}, err
+}
+
+// etag formats a profile version as a strong entity tag
+func etag(version int64) string {
+	return `"v` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch extracts the expected version from an If-Match header.
+// A missing header or "*" skips the concurrency check.
+func parseIfMatch(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return db.AnyVersion, nil
+	}
+
+	tag := strings.TrimPrefix(header, "W/")
+	tag = strings.Trim(tag, `"`)
+	if !strings.HasPrefix(tag, "v") {
+		return 0, errors.New("malformed entity tag")
+	}
+	return strconv.ParseInt(tag[1:], 10, 64)
 }
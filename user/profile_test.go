@@ -0,0 +1,173 @@
+package user
+
+import (
+	"astromatch/auth"
+	"astromatch/db"
+	"astromatch/db/memory"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestHandler(t *testing.T, users ...db.User) *Handler {
+	t.Helper()
+	store := memory.NewStore()
+	for _, u := range users {
+		if err := store.Users.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewHandler(store)
+}
+
+// profileRequest calls GetOrUpdateProfile as userID
+func profileRequest(h *Handler, userID, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	r = r.WithContext(auth.WithClaims(r.Context(), &auth.Claims{UserID: userID}))
+	w := httptest.NewRecorder()
+	h.GetOrUpdateProfile(w, r)
+	return w
+}
+
+func TestProfileETagRoundTrip(t *testing.T) {
+	h := newTestHandler(t, db.User{ID: "ada", Name: "Ada", Email: "ada@example.com"})
+
+	w := profileRequest(h, "ada", http.MethodGet, "/api/user/profile", "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"v0"` {
+		t.Fatalf("GET: status %d, ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	patch := http.Header{"Content-Type": {"application/merge-patch+json"}, "If-Match": {`"v0"`}}
+	w = profileRequest(h, "ada", http.MethodPatch, "/api/user/profile", `{"bio":"Stargazer"}`, patch)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH: status %d: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != `"v1"` {
+		t.Errorf("ETag after update = %q, want \"v1\"", w.Header().Get("ETag"))
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["bio"] != "Stargazer" || body["name"] != "Ada" {
+		t.Errorf("merge patch result: %v", body)
+	}
+
+	w = profileRequest(h, "ada", http.MethodPatch, "/api/user/profile", `{"bio":"Lost update"}`, patch)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: status %d, want 412", w.Code)
+	}
+}
+
+func TestProfileUpdateRejectsProtectedFields(t *testing.T) {
+	h := newTestHandler(t,
+		db.User{ID: "ada", Name: "Ada", Email: "ada@example.com"},
+		db.User{ID: "bob", Name: "Bob", Email: "bob@example.com"},
+	)
+	patch := http.Header{"Content-Type": {"application/merge-patch+json"}}
+
+	for _, body := range []string{`{"email":"bob@example.com"}`, `{"role":"admin"}`, `{"isVerified":true}`, `{"name":null}`, `{"profilePic":"https://elsewhere.example/me.jpg"}`} {
+		if w := profileRequest(h, "ada", http.MethodPatch, "/api/user/profile", body, patch); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+
+	if w := profileRequest(h, "ada", http.MethodPatch, "/api/user/profile?id=bob", `{"bio":"hi"}`, patch); w.Code != http.StatusForbidden {
+		t.Errorf("updating someone else: status %d, want 403", w.Code)
+	}
+
+	user, err := h.store.Users.GetByID(context.Background(), "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "ada@example.com" || user.Role != "" || user.IsVerified || user.Version != 0 {
+		t.Errorf("rejected updates changed the account: %+v", user)
+	}
+}
+
+func TestProfileOfMissingUser(t *testing.T) {
+	h := newTestHandler(t, db.User{ID: "ada", Name: "Ada"})
+	if w := profileRequest(h, "ada", http.MethodGet, "/api/user/profile?id=nobody", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", w.Code)
+	}
+}
+
+func TestProfileLocationMergePatch(t *testing.T) {
+	h := newTestHandler(t,
+		db.User{ID: "ada", Name: "Ada", Location: &db.GeoPoint{Lat: 51.5, Lng: -0.1}},
+		db.User{ID: "bob", Name: "Bob"},
+	)
+	patch := http.Header{"Content-Type": {"application/merge-patch+json"}}
+
+	tests := []struct {
+		body string
+		want db.GeoPoint
+	}{
+		{`{"location":{"lat":48.9}}`, db.GeoPoint{Lat: 48.9, Lng: -0.1}},
+		{`{"location":{"lng":2.3}}`, db.GeoPoint{Lat: 48.9, Lng: 2.3}},
+		{`{"location":{}}`, db.GeoPoint{Lat: 48.9, Lng: 2.3}},
+		{`{"location":{"lat":0,"lng":0}}`, db.GeoPoint{}},
+	}
+	for _, tt := range tests {
+		if w := profileRequest(h, "ada", http.MethodPatch, "/api/user/profile", tt.body, patch); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.body, w.Code, w.Body)
+		}
+		user, err := h.store.Users.GetByID(context.Background(), "ada")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Location == nil || *user.Location != tt.want {
+			t.Errorf("%s: location %+v, want %+v", tt.body, user.Location, tt.want)
+		}
+	}
+
+	// Removing a coordinate, or naming one where there is no location to merge
+	// into, leaves the location incomplete
+	for _, req := range []struct{ userID, body string }{
+		{"ada", `{"location":{"lat":null}}`},
+		{"bob", `{"location":{"lat":1}}`},
+		{"bob", `{"location":{}}`},
+	} {
+		if w := profileRequest(h, req.userID, http.MethodPatch, "/api/user/profile", req.body, patch); w.Code != http.StatusBadRequest {
+			t.Errorf("%s as %s: status %d, want 400", req.body, req.userID, w.Code)
+		}
+	}
+
+	if w := profileRequest(h, "ada", http.MethodPatch, "/api/user/profile", `{"location":null}`, patch); w.Code != http.StatusOK {
+		t.Fatalf("removing location: status %d", w.Code)
+	}
+	if user, _ := h.store.Users.GetByID(context.Background(), "ada"); user.Location != nil {
+		t.Errorf("location after null patch: %+v", user.Location)
+	}
+}
+
+func TestProfileReplaceNeedsWholeLocation(t *testing.T) {
+	h := newTestHandler(t, db.User{ID: "ada", Name: "Ada", Location: &db.GeoPoint{Lat: 51.5, Lng: -0.1}})
+	put := http.Header{"Content-Type": {"application/json"}}
+	if w := profileRequest(h, "ada", http.MethodPut, "/api/user/profile", `{"name":"Ada","location":{"lat":1}}`, put); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with half a location: status %d, want 400", w.Code)
+	}
+}
+
+func TestProfileReplaceKeepsProfilePic(t *testing.T) {
+	photo := db.Photo{ID: "p1", URL: "/media/users/ada/photos/p1/medium.jpg"}
+	h := newTestHandler(t, db.User{ID: "ada", Name: "Ada", Photos: []db.Photo{photo}, ProfilePic: photo.URL})
+
+	put := http.Header{"Content-Type": {"application/json"}}
+	if w := profileRequest(h, "ada", http.MethodPut, "/api/user/profile", `{"name":"Ada L"}`, put); w.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d, body %s", w.Code, w.Body)
+	}
+	user, err := h.store.Users.GetByID(context.Background(), "ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Ada L" || user.ProfilePic != photo.URL {
+		t.Errorf("PUT without profilePic: name %q, profilePic %q", user.Name, user.ProfilePic)
+	}
+}